@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zrma/uds-go/pkg/api"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// print writes v as JSON, or calls text to describe it to a human
+func (o *output) print(v interface{}, text func(w io.Writer)) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: uds %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// resolve looks up a UDS file by id first, then by its exact name
+func resolve(ctx context.Context, service *api.Service, idOrName string) (*uds.File, error) {
+	if file, err := service.GetFile(ctx, idOrName); err == nil {
+		return file, nil
+	}
+
+	files, err := service.FindFiles(ctx, idOrName)
+	if err != nil {
+		return nil, err
+	}
+	switch len(files) {
+	case 0:
+		return nil, fmt.Errorf("no file matches %q", idOrName)
+	case 1:
+		return files[0], nil
+	}
+	return nil, fmt.Errorf("%d files are named %q, use an id instead", len(files), idOrName)
+}
+
+func push(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("push", "[--parent ID] [--compress gzip|zstd] [--codec NAME] [--resume] <file>...")
+	parent := fs.String("parent", "", "id of the folder to upload into, the UDS root by default")
+	compress := fs.String("compress", "", "compress files with gzip or zstd, unless they are compressed already")
+	resume := fs.Bool("resume", false, "complete the unfinished uploads of the files, if any")
+	codec := fs.String("codec", "", "encode chunks with one of "+strings.Join(uds.CodecNames(), ", ")+", base64 by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := uds.CodecByName(*codec); err != nil {
+		return err
+	}
+	service.Codec = *codec
+	if !uds.ValidCompression(*compress) {
+		return fmt.Errorf("unsupported compression %q", *compress)
+	}
+	service.Compression = *compress
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no file to push")
+	}
+
+	var files []*uds.File
+	for _, path := range fs.Args() {
+		var (
+			file *uds.File
+			err  error
+		)
+		if *resume {
+			file, err = service.ResumeUpload(ctx, path)
+		}
+		if !*resume || err == api.ErrNothingToResume {
+			file, err = service.Upload(ctx, path, *parent)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		files = append(files, file)
+	}
+
+	return out.print(files, func(w io.Writer) {
+		for _, f := range files {
+			_, _ = fmt.Fprintf(w, "pushed %s\t%s\t%s\n", f.Name, f.Size, f.ID)
+		}
+	})
+}
+
+func pull(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("pull", "<id|name> [dest]")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("expected a file and an optional destination")
+	}
+
+	file, err := resolve(ctx, service, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	dest := ""
+	if fs.NArg() == 2 {
+		dest = fs.Arg(1)
+	}
+	dest, err = pullDest(dest, file.Name)
+	if err != nil {
+		return err
+	}
+	if err := service.Download(ctx, file.ID, dest); err != nil {
+		return err
+	}
+
+	result := struct {
+		*uds.File
+		Path string `json:"path"`
+	}{file, dest}
+	return out.print(result, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "pulled %s\t%s\t%s\n", file.Name, file.Size, dest)
+	})
+}
+
+// pullDest returns where to pull the file called name in Drive: dest when it
+// is a file path, or the file of that name in dest, or in the working
+// directory when dest is empty
+func pullDest(dest, name string) (string, error) {
+	if info, err := api.AppFs.Stat(dest); dest == "" || err == nil && info.IsDir() {
+		base, err := localName(name)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dest, base), nil
+	}
+	return dest, nil
+}
+
+// localName returns the name of a file in Drive as a local file name. Names
+// are chosen by whoever shared the folder, so that directories are dropped
+// and names that do not make a file are refused.
+func localName(name string) (string, error) {
+	base := filepath.Base(name)
+	switch base {
+	case "", ".", "..", string(filepath.Separator):
+		return "", fmt.Errorf("%q cannot be used as a local file name, give a destination file", name)
+	}
+	return base, nil
+}
+
+func list(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("ls", "[--trashed] [query]")
+	trashed := fs.Bool("trashed", false, "include files in the trash")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("expected a single query")
+	}
+
+	files := []*uds.File{}
+	opts := api.ListOptions{Query: fs.Arg(0), IncludeTrashed: *trashed}
+	err := service.ListFilesIter(ctx, opts, func(f *uds.File) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.print(files, func(w io.Writer) {
+		_, _ = fmt.Fprintln(w, "ID\tSIZE\tNAME")
+		for _, f := range files {
+			name := f.Name
+			if f.Trashed {
+				name += " (trashed)"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", f.ID, f.Size, name)
+		}
+	})
+}
+
+func remove(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("rm", "[--hard] <id|name>...")
+	hard := fs.Bool("hard", false, "delete permanently instead of moving to the trash")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no file to remove")
+	}
+
+	var files []*uds.File
+	for _, arg := range fs.Args() {
+		file, err := resolve(ctx, service, arg)
+		if err != nil {
+			return err
+		}
+		if err := service.Delete(ctx, file.ID, *hard); err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+		files = append(files, file)
+	}
+
+	return out.print(files, func(w io.Writer) {
+		for _, f := range files {
+			_, _ = fmt.Fprintf(w, "removed %s\t%s\n", f.Name, f.ID)
+		}
+	})
+}
+
+func restore(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("restore", "<id>...")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no file to restore")
+	}
+
+	var files []*uds.File
+	for _, id := range fs.Args() {
+		if err := service.Restore(ctx, id); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		file, err := service.GetFile(ctx, id)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	return out.print(files, func(w io.Writer) {
+		for _, f := range files {
+			_, _ = fmt.Fprintf(w, "restored %s\t%s\n", f.Name, f.ID)
+		}
+	})
+}
+
+func emptyTrash(ctx context.Context, service *api.Service, out *output, args []string) error {
+	if len(args) != 0 {
+		return errors.New("empty-trash takes no arguments")
+	}
+
+	n, err := service.EmptyTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Deleted int `json:"deleted"`
+	}{n}
+	return out.print(result, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "deleted %d files\n", n)
+	})
+}
+
+func info(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("info", "<id|name>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single file")
+	}
+
+	file, err := resolve(ctx, service, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return out.print(file, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "Name:\t%s\n", file.Name)
+		_, _ = fmt.Fprintf(w, "ID:\t%s\n", file.ID)
+		_, _ = fmt.Fprintf(w, "Mime:\t%s\n", file.Mime)
+		_, _ = fmt.Fprintf(w, "Size:\t%s (%s bytes)\n", file.Size, file.SizeNumeric)
+		if file.EncodedSize != "" {
+			_, _ = fmt.Fprintf(w, "Encoded size:\t%s\n", file.EncodedSize)
+		}
+		_, _ = fmt.Fprintf(w, "MD5:\t%s\n", file.MD5)
+		if file.SHA256 != "" {
+			_, _ = fmt.Fprintf(w, "SHA-256:\t%s\n", file.SHA256)
+		}
+		_, _ = fmt.Fprintf(w, "Shared:\t%t\n", file.Shared)
+		_, _ = fmt.Fprintf(w, "Trashed:\t%t\n", file.Trashed)
+	})
+}
+
+func verify(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("verify", "<id|name>...")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no file to verify")
+	}
+
+	var (
+		results []*api.Verification
+		failed  int
+	)
+	for _, arg := range fs.Args() {
+		file, err := resolve(ctx, service, arg)
+		if err != nil {
+			return err
+		}
+		v, err := service.Verify(ctx, file.ID)
+		if err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+		if !v.OK() {
+			failed++
+		}
+		results = append(results, v)
+	}
+
+	err := out.print(results, func(w io.Writer) {
+		for _, v := range results {
+			status := "ok"
+			if !v.OK() {
+				status = "FAILED"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", status, v.Name, v.ID)
+			for _, problem := range []struct {
+				name  string
+				parts []int64
+			}{
+				{"missing parts", v.Missing},
+				{"duplicated parts", v.Duplicated},
+				{"corrupt parts", v.Corrupt},
+				{"out of order parts", v.OutOfOrder},
+			} {
+				if len(problem.parts) > 0 {
+					_, _ = fmt.Fprintf(w, "  %s:\t%s\n", problem.name, joinParts(problem.parts))
+				}
+			}
+			if len(v.Unexpected) > 0 {
+				_, _ = fmt.Fprintf(w, "  unexpected docs:\t%s\n", strings.Join(v.Unexpected, ", "))
+			}
+			switch {
+			case v.HashMismatch:
+				_, _ = fmt.Fprintf(w, "  checksum:\tmismatch\n")
+			case !v.Hashed:
+				_, _ = fmt.Fprintf(w, "  checksum:\tnot checked\n")
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed verification", failed, len(results))
+	}
+	return nil
+}
+
+func fsck(ctx context.Context, service *api.Service, out *output, args []string) error {
+	fs := newFlagSet("fsck", "[--delete|--quarantine] [--dry-run] [--min-age DURATION]")
+	del := fs.Bool("delete", false, "permanently delete the broken files found")
+	quarantine := fs.Bool("quarantine", false, "move the broken files found to a quarantine folder")
+	dryRun := fs.Bool("dry-run", false, "only report what --delete or --quarantine would do")
+	minAge := fs.Duration("min-age", api.DefaultRepairMinAge, "keep incomplete files changed more recently, as they may still be uploading")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("fsck takes no arguments")
+	}
+	if *del && *quarantine {
+		return errors.New("--delete and --quarantine are exclusive")
+	}
+
+	report, err := service.Scan(ctx)
+	if err != nil {
+		return err
+	}
+	if *del || *quarantine {
+		opts := api.RepairOptions{Action: api.RepairDelete, DryRun: *dryRun, MinAge: *minAge}
+		if *quarantine {
+			opts.Action = api.RepairQuarantine
+		}
+		if err := service.Repair(ctx, report, opts); err != nil {
+			return err
+		}
+	}
+
+	return out.print(report, func(w io.Writer) {
+		for _, p := range report.Problems {
+			action := ""
+			switch {
+			case p.Action == api.RepairKeep && p.Resumable:
+				action = "kept, resume with push --resume"
+			case p.Action == api.RepairKeep:
+				action = "kept, changed in the last " + minAge.String()
+			case p.Done:
+				action = p.Action + "d"
+			case p.Action != "":
+				action = "would " + p.Action
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Kind, p.Name, p.ID, p.Detail, action)
+		}
+		_, _ = fmt.Fprintf(w, "scanned %d files, found %d problems\n", report.Files, len(report.Problems))
+	})
+}
+
+func joinParts(parts []int64) string {
+	s := make([]string, len(parts))
+	for i, part := range parts {
+		s[i] = strconv.FormatInt(part, 10)
+	}
+	return strings.Join(s, ", ")
+}
+
+func whoami(ctx context.Context, service *api.Service, out *output, args []string) error {
+	if len(args) != 0 {
+		return errors.New("whoami takes no arguments")
+	}
+
+	about, err := service.About(ctx)
+	if err != nil {
+		return err
+	}
+
+	return out.print(about, func(w io.Writer) {
+		if about.User != nil {
+			_, _ = fmt.Fprintf(w, "%s <%s>\n", about.User.DisplayName, about.User.EmailAddress)
+		}
+		if q := about.StorageQuota; q != nil {
+			limit := "unlimited"
+			if q.Limit > 0 {
+				limit = uds.FormatSize(q.Limit)
+			}
+			_, _ = fmt.Fprintf(w, "Storage used:\t%s of %s\n", uds.FormatSize(q.Usage), limit)
+		}
+	})
+}
+
+func showUsage(_ context.Context, service *api.Service, out *output, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage takes no arguments")
+	}
+
+	u, err := service.Usage()
+	if err != nil {
+		return err
+	}
+
+	return out.print(u, func(w io.Writer) {
+		budget := "unlimited"
+		if service.Limits.DailyUploadBytes > 0 {
+			budget = uds.FormatSize(service.Limits.DailyUploadBytes)
+		}
+		_, _ = fmt.Fprintf(w, "Day:\t%s (UTC)\n", u.Day)
+		_, _ = fmt.Fprintf(w, "Requests:\t%d\n", u.Requests)
+		_, _ = fmt.Fprintf(w, "Uploaded:\t%s of %s\n", uds.FormatSize(u.UploadedBytes), budget)
+	})
+}
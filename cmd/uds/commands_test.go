@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrma/uds-go/pkg/api"
+)
+
+func TestLocalName(t *testing.T) {
+	for _, tc := range []struct {
+		given string
+		want  string
+	}{
+		{"a.txt", "a.txt"},
+		{"a/b", "b"},
+		{"../x", "x"},
+		{"../../etc/passwd", "passwd"},
+	} {
+		got, err := localName(tc.given)
+		assert.NoError(t, err, tc.given)
+		assert.Equal(t, tc.want, got)
+	}
+
+	for _, name := range []string{"", ".", "..", "/", "a/.."} {
+		_, err := localName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPullDest(t *testing.T) {
+	fsBackup := api.AppFs
+	api.AppFs = afero.NewMemMapFs()
+	t.Cleanup(func() {
+		api.AppFs = fsBackup
+	})
+	assert.NoError(t, api.AppFs.MkdirAll("/downloads", 0700))
+
+	for _, tc := range []struct {
+		description string
+		dest        string
+		name        string
+		want        string
+	}{
+		{description: "working directory", name: "a.txt", want: "a.txt"},
+		{description: "directory", dest: "/downloads", name: "a.txt", want: "/downloads/a.txt"},
+		{description: "directory and traversal", dest: "/downloads", name: "../../a.txt", want: "/downloads/a.txt"},
+		{description: "file path", dest: "/downloads/b.txt", name: "a.txt", want: "/downloads/b.txt"},
+		{description: "file path takes any name", dest: "/downloads/b.txt", name: "..", want: "/downloads/b.txt"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			got, err := pullDest(tc.dest, tc.name)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := pullDest("", "..")
+	assert.Error(t, err)
+	_, err = pullDest("/downloads", "/")
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/zrma/uds-go/pkg/api"
+)
+
+const usage = `Usage: uds [--json] <command> [arguments]
+
+Commands:
+  push [--parent ID] [--compress gzip|zstd] [--codec NAME] [--resume] <file>...
+                                upload local files
+  pull <id|name> [dest]         download a file, named as stored by default
+  ls [--trashed] [query]        list files whose name contains query
+  rm [--hard] <id|name>...      move files to the trash, or delete them
+  restore <id>...               take files back out of the trash
+  empty-trash                   permanently delete every file in the trash
+  info <id|name>                show details of a file
+  verify <id|name>...           check the chunks and checksum of files
+  fsck [--delete|--quarantine] [--dry-run] [--min-age DURATION]
+                                find orphaned chunks, incomplete files and
+                                duplicated chunks, and clean them up
+  whoami                        show the signed in account
+  usage                         show the requests and uploads of the day
+
+Files are encrypted on push and decrypted on pull when a passphrase is given
+with --passphrase-file or the UDS_PASSPHRASE environment variable.
+
+Flags:
+`
+
+type command func(ctx context.Context, service *api.Service, out *output, args []string) error
+
+var commands = map[string]command{
+	"push":        push,
+	"pull":        pull,
+	"ls":          list,
+	"rm":          remove,
+	"restore":     restore,
+	"empty-trash": emptyTrash,
+	"info":        info,
+	"verify":      verify,
+	"fsck":        fsck,
+	"whoami":      whoami,
+	"usage":       showUsage,
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	jsonOutput := flag.Bool("json", false, "print results as JSON")
+	passphraseFile := flag.String("passphrase-file", "", "read the encryption passphrase from this file")
+	workers := flag.Int("workers", api.DefaultWorkers, "number of chunks transferred at a time")
+	stateDir := flag.String("state-dir", "", "directory keeping the local state, $XDG_STATE_HOME/uds by default")
+	rate := flag.Float64("rate", 0, "maximum Drive requests per second, unlimited by default")
+	dailyUpload := flag.Int64("daily-upload", 0, "maximum bytes uploaded per day, unlimited by default")
+	noBrowser := flag.Bool("no-browser", false, "sign in on another device with a code instead of opening a browser")
+	callbackPort := flag.Int("callback-port", 0, "loopback port the browser sign in redirects to, a free one by default")
+	signInTimeout := flag.Duration("sign-in-timeout", api.DefaultCallbackTimeout, "how long the browser sign in is waited for")
+	credentials := flag.String("credentials", "", "OAuth client or service account key, $UDS_CREDENTIALS or $XDG_CONFIG_HOME/uds/credentials.json by default")
+	token := flag.String("token", "", "file keeping the OAuth token, $UDS_TOKEN or $XDG_CONFIG_HOME/uds/token.json by default")
+	subject := flag.String("subject", "", "user a service account acts on behalf of, through domain-wide delegation")
+	waitForBudget := flag.Bool("wait-for-budget", false, "pause uploads until the next day once the daily budget is spent")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *noBrowser {
+		api.Helper.OpenBrowser = nil
+	}
+	api.Helper.CallbackPort = *callbackPort
+	api.Helper.CallbackTimeout = *signInTimeout
+	service := &api.Service{
+		Subject:         *subject,
+		CredentialsFile: *credentials,
+		TokenFile:       *token,
+	}
+	err := service.Init()
+	if err != nil {
+		log.Fatalf("Unable to retrieve NewService: %v", err)
+	}
+	if service.Passphrase, err = passphrase(*passphraseFile); err != nil {
+		log.Fatalf("Unable to read passphrase: %v", err)
+	}
+	service.Workers = *workers
+	service.Limits = api.Limits{
+		RequestsPerSecond: *rate,
+		DailyUploadBytes:  *dailyUpload,
+		WaitForBudget:     *waitForBudget,
+	}
+	if service.StateDir = *stateDir; service.StateDir == "" {
+		if service.StateDir, err = api.DefaultStateDir(); err != nil {
+			log.Fatalf("Unable to locate state directory: %v", err)
+		}
+	}
+
+	out := &output{w: os.Stdout, json: *jsonOutput}
+	err = run(context.Background(), service, out, flag.Args()[1:])
+	if err := service.Close(); err != nil {
+		log.Printf("Unable to save usage: %v", err)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// passphrase returns the first line of path, or UDS_PASSPHRASE when path is empty
+func passphrase(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(os.Getenv("UDS_PASSPHRASE")), nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	line := strings.SplitN(string(b), "\n", 2)[0]
+	return []byte(strings.TrimRight(line, "\r")), nil
+}
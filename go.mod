@@ -6,9 +6,11 @@ require (
 	github.com/go-test/deep v1.0.2
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/google/go-cmp v0.3.1 // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/spf13/afero v1.6.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sys v0.0.0-20200122134326-e047566fdf82 // indirect
@@ -1,9 +1,9 @@
 package api
 
 import (
+	"errors"
 	"fmt"
-	"path/filepath"
-	"runtime"
+	"log"
 
 	"github.com/spf13/afero"
 	"golang.org/x/net/context"
@@ -19,6 +19,11 @@ func init() {
 	AppFs = afero.NewOsFs()
 }
 
+const (
+	folderMimeType = "application/vnd.google-apps.folder"
+	docMimeType    = "application/vnd.google-apps.document"
+)
+
 // NewService function returns initialized Service object's pointer
 func NewService() (*Service, error) {
 	api := &Service{}
@@ -28,123 +33,240 @@ func NewService() (*Service, error) {
 	return api, nil
 }
 
+// NewServiceWithSubject function returns initialized Service object's pointer
+// acting on behalf of subject, a user of the Google Workspace domain that
+// granted domain-wide delegation to the service account of the credentials
+func NewServiceWithSubject(subject string) (*Service, error) {
+	api := &Service{Subject: subject}
+	if err := api.Init(); err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// NewServiceWithBackend function returns Service storing files in backend
+func NewServiceWithBackend(backend Backend) *Service {
+	api := &Service{ctx: context.Background()}
+	api.backend = api.wrap(backend)
+	return api
+}
+
 // Service struct is google api service wrapper.
 type Service struct {
 	*drive.Service
-	ctx context.Context
+	ctx     context.Context
+	backend Backend
+	limiter *limiter
+
+	// StateDir keeps the local state of the service, like the usage of the
+	// day. Nothing is kept when empty.
+	StateDir string
+	// Limits throttles the Drive calls and caps the bytes uploaded per day
+	Limits Limits
+	// Retry tells how the Drive calls failing with a retryable error are retried
+	Retry Retry
+	// Workers is the number of chunks uploaded or downloaded at a time,
+	// DefaultWorkers when not set
+	Workers int
+	// Codec is the name of the codec encoding the chunks of uploaded files,
+	// base64 when empty
+	Codec string
+	// Compression compresses the chunks of uploaded files with the given
+	// algorithm, unless their mime type is known to be compressed already
+	Compression string
+	// Passphrase encrypts the chunks of uploaded files when set, and is
+	// needed to read files that were uploaded with one
+	Passphrase []byte
+	// Subject is the user a service account acts on behalf of through
+	// domain-wide delegation, the service account itself when empty
+	Subject string
+	// CredentialsFile is the OAuth client or service account key Init signs
+	// in with. UDS_CREDENTIALS, then credentials.json in DefaultConfigDir, are
+	// used when empty.
+	CredentialsFile string
+	// TokenFile keeps the token of the OAuth client. UDS_TOKEN, then
+	// token.json in DefaultConfigDir, are used when empty.
+	TokenFile string
 }
 
 // Init works internally but public(export) for using in apt_test package
 func (api *Service) Init() error {
-	_, caller, _, _ := runtime.Caller(2)
-	basePath := filepath.Dir(caller)
-
-	afs := &afero.Afero{Fs: AppFs}
-	b, err := afs.ReadFile(filepath.Join(basePath, credentialFile))
+	b, err := api.credentials()
 	if err != nil {
 		return err
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := Helper.ConfigFromJSON(b, drive.DriveScope)
+	ctx := context.Background()
+	ts, err := api.tokenSource(ctx, b)
 	if err != nil {
 		return err
 	}
+	return api.initDrive(ctx, option.WithTokenSource(ts))
+}
 
-	token, err := Helper.GetToken(config, filepath.Join(basePath, tokenFile))
-	if err != nil {
-		return err
+// NewServiceWithOptions function returns Service talking to Drive with the
+// given client options, e.g. option.WithEndpoint for a local stand-in
+func NewServiceWithOptions(ctx context.Context, opts ...option.ClientOption) (*Service, error) {
+	api := &Service{}
+	if err := api.initDrive(ctx, opts...); err != nil {
+		return nil, err
 	}
+	return api, nil
+}
 
-	api.ctx = context.Background()
-	driveService, err := drive.NewService(
-		api.ctx,
-		option.WithTokenSource(config.TokenSource(api.ctx, token)),
-	)
+func (api *Service) initDrive(ctx context.Context, opts ...option.ClientOption) error {
+	driveService, err := drive.NewService(ctx, opts...)
 	if err != nil {
 		return err
 	}
 
+	api.ctx = ctx
 	api.Service = driveService
+	api.backend = api.wrap(NewDriveBackend(driveService))
 	return nil
 }
 
+// wrap returns backend with the limits and the retries of api applied, in
+// this order, so that every attempt is throttled and accounted for
+func (api *Service) wrap(backend Backend) Backend {
+	api.limiter = &limiter{limits: &api.Limits, stateDir: &api.StateDir}
+	return &retryBackend{
+		backend: &limitBackend{backend: backend, limiter: api.limiter},
+		retry:   &api.Retry,
+	}
+}
+
 // GetBaseFolder locate the base UDS folder
 func (api *Service) GetBaseFolder() (*drive.File, error) {
-	r, err := api.Files.List().
-		Q("properties has {key='udsRoot' and value='true'} and trashed=false").
-		PageSize(1).
-		Fields("nextPageToken, files(id, name, properties)").Do()
+	q := NewQuery().Property(uds.RootProperty, "true").Trashed(false)
+	var roots []*drive.File
+	err := api.listAll(api.ctx, q.String(), func(f *drive.File) error {
+		// media folders of early releases were tagged as root as well, and
+		// may fill pages before the root
+		if _, err := uds.ParseMetadata(f.Properties); err != nil {
+			roots = append(roots, f)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve files: %v", err)
+		return nil, err
 	}
 
-	fileLength := len(r.Files)
+	fileLength := len(roots)
 	if fileLength == 0 {
-		fmt.Println("No files found.")
+		log.Println("No files found.")
 		return api.createRootFolder()
 	} else if fileLength == 1 {
-		for _, i := range r.Files {
-			fmt.Printf("%s (%s)\n", i.Name, i.Id)
+		for _, i := range roots {
+			log.Printf("%s (%s)\n", i.Name, i.Id)
 		}
-		return r.Files[0], nil
+		return roots[0], nil
 	}
 	return nil, fmt.Errorf("multiple UDS Roots found")
 }
 
 func (api *Service) createRootFolder() (*drive.File, error) {
-	return api.Files.Create(&drive.File{
+	return api.backend.CreateFolder(api.ctx, &drive.File{
 		Name:       "UDS Root",
-		MimeType:   "application/vnd.google-apps.folder",
-		Properties: map[string]string{"udsRoot": "true"},
+		MimeType:   folderMimeType,
+		Properties: map[string]string{uds.RootProperty: "true"},
 		Parents:    []string{},
-	}).Fields("id").Do()
+	})
 }
 
+// CreateMediaFolder creates the folder holding every chunk Doc of media
 func (api *Service) CreateMediaFolder(media *uds.File) (*drive.File, error) {
-	return api.Files.Create(&drive.File{
-		Name:     media.Name,
-		MimeType: "application/vnd.google-apps.folder",
-		Properties: map[string]string{
-			"udsRoot":      "true",
-			"size":         media.Size,
-			"size_numeric": media.SizeNumeric,
-			"encoded_size": media.EncodedSize,
-			"md5":          media.MD5,
-		},
-		Parents: media.Parents,
-	}).Fields("id").Do()
+	meta, err := media.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return api.createMediaFolder(media, meta)
+}
+
+func (api *Service) createMediaFolder(media *uds.File, meta *uds.Metadata) (*drive.File, error) {
+	return api.backend.CreateFolder(api.ctx, &drive.File{
+		Name:       media.Name,
+		MimeType:   folderMimeType,
+		Properties: meta.Properties(),
+		Parents:    media.Parents,
+	})
 }
 
+// ListOptions struct narrows down the files returned by ListFilesIter
+type ListOptions struct {
+	// Query matches a part of the file name
+	Query string
+	// Filter adds further conditions, e.g. NewQuery().MinSize(1 << 20)
+	Filter *Query
+	// IncludeTrashed lists the files in the trash as well
+	IncludeTrashed bool
+}
+
+// ErrStopIteration can be returned from a ListFilesIter callback to stop early
+var ErrStopIteration = errors.New("stop iteration")
+
+// ListFiles returns every UDS file whose name contains query
 func (api *Service) ListFiles(query string) ([]*uds.File, error) {
-	q := "properties has {key='uds' and value='true'} and trashed=false"
-	if query != "" {
-		q += fmt.Sprintf(" and name contains '%s'", query)
+	var files []*uds.File
+	err := api.ListFilesIter(api.ctx, ListOptions{Query: query}, func(file *uds.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return files, nil
+}
 
-	r, err := api.Files.List().
-		Q(q).
-		PageSize(1000).
-		Fields("nextPageToken, files(id, name, properties, mimeType)").Do()
-	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve files: %v", err)
+// ListFilesIter calls fn for every UDS file matching opts, fetching the
+// listing page by page. Returning ErrStopIteration from fn ends the listing
+// without error, any other error is returned as is.
+func (api *Service) ListFilesIter(ctx context.Context, opts ListOptions, fn func(*uds.File) error) error {
+	q := NewQuery().Property(uds.MediaProperty, "true")
+	if !opts.IncludeTrashed {
+		q.Trashed(false)
+	}
+	if opts.Query != "" {
+		q.NameContains(opts.Query)
 	}
+	q.And(opts.Filter)
 
-	var files []*uds.File
-	for _, f := range r.Files {
-		props := f.Properties
-		file := &uds.File{
-			Name:        props["name"],
-			Mime:        props["mimeType"],
-			Size:        props["size"],
-			EncodedSize: props["encoded_size"],
-			SizeNumeric: props["size_numeric"],
-			ID:          props["id"],
-			MD5:         props["md5Checksum"],
-			Shared:      props["shared"] == "true",
+	err := api.listAll(ctx, q.String(), func(f *drive.File) error {
+		if !q.Match(f) {
+			return nil
 		}
-		file.Init()
-		files = append(files, file)
+		meta, err := uds.ParseMetadata(f.Properties)
+		if err != nil {
+			// not something this version can read, e.g. written by a newer one
+			return nil
+		}
+		file := meta.File(f.Name, f.Id, f.Parents)
+		file.Shared = f.Shared
+		file.Trashed = f.Trashed
+		return fn(file)
+	})
+	if err == ErrStopIteration {
+		return nil
+	}
+	return err
+}
+
+// listAll calls fn for every file matching q, following the page tokens
+func (api *Service) listAll(ctx context.Context, q string, fn func(*drive.File) error) error {
+	pageToken := ""
+	for {
+		r, err := api.backend.List(ctx, q, pageToken)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve files: %v", err)
+		}
+		for _, f := range r.Files {
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+		if r.NextPageToken == "" {
+			return nil
+		}
+		pageToken = r.NextPageToken
 	}
-	return files, nil
 }
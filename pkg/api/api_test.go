@@ -1,20 +1,27 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
-	"path/filepath"
-	"runtime"
-	"strings"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
 )
 
 func TestService(t *testing.T) {
@@ -29,6 +36,9 @@ func TestService(t *testing.T) {
 			AppFs = fsBackup
 			Helper = helperBackup
 		})
+		setEnv(t, "XDG_CONFIG_HOME", "/config")
+		setEnv(t, CredentialsEnv, "")
+		setEnv(t, TokenEnv, "")
 
 		service := &Service{}
 		return service, afs
@@ -52,16 +62,14 @@ func TestService(t *testing.T) {
 	t.Run("success to initialize", func(t *testing.T) {
 		service, afs := setup()
 
-		_, caller, _, _ := runtime.Caller(1)
-		basePath := filepath.Dir(caller)
-
-		f, err := afs.Create(filepath.Join(basePath, credentialFile))
+		f, err := afs.Create("/config/uds/credentials.json")
 		assert.NoError(t, err)
 
 		_, err = f.Write([]byte(credential))
 		assert.NoError(t, err)
 
 		Helper.GetToken = func(config *oauth2.Config, fileName string) (token *oauth2.Token, err error) {
+			assert.Equal(t, "/config/uds/token.json", fileName)
 			return &oauth2.Token{}, nil
 		}
 
@@ -73,16 +81,13 @@ func TestService(t *testing.T) {
 		service, _ := setup()
 
 		err := service.Init()
-		assert.Error(t, err)
+		assert.EqualError(t, err, "no credentials at /config/uds/credentials.json, give them with UDS_CREDENTIALS or put them there")
 	})
 
 	t.Run("handle credentials.json reading error", func(t *testing.T) {
 		service, afs := setup()
 
-		_, caller, _, _ := runtime.Caller(1)
-		basePath := filepath.Dir(caller)
-
-		f, err := afs.Create(filepath.Join(basePath, credentialFile))
+		f, err := afs.Create("/config/uds/credentials.json")
 		assert.NoError(t, err)
 
 		given := []byte("invalid json")
@@ -104,10 +109,7 @@ func TestService(t *testing.T) {
 	t.Run("GetToken error", func(t *testing.T) {
 		service, afs := setup()
 
-		_, caller, _, _ := runtime.Caller(1)
-		basePath := filepath.Dir(caller)
-
-		f, err := afs.Create(filepath.Join(basePath, credentialFile))
+		f, err := afs.Create("/config/uds/credentials.json")
 		assert.NoError(t, err)
 
 		_, err = f.Write([]byte(credential))
@@ -122,39 +124,79 @@ func TestService(t *testing.T) {
 		assert.Error(t, err)
 		assert.EqualError(t, err, given.Error())
 	})
+
+	t.Run("service account", func(t *testing.T) {
+		service, afs := setup()
+		service.Subject = "admin@example.com"
+
+		key := serviceAccountKey(t, "https://oauth2.googleapis.com/token")
+		assert.NoError(t, afs.WriteFile("/config/uds/credentials.json", key, 0600))
+
+		Helper.GetToken = func(*oauth2.Config, string) (*oauth2.Token, error) {
+			return nil, errors.New("service accounts need no browser")
+		}
+		var got *jwt.Config
+		Helper.JWTConfigFromJSON = func(jsonKey []byte, scope ...string) (*jwt.Config, error) {
+			config, err := google.JWTConfigFromJSON(jsonKey, scope...)
+			got = config
+			return config, err
+		}
+
+		assert.NoError(t, service.Init())
+		if assert.NotNil(t, got) {
+			assert.Equal(t, "uds@project.iam.gserviceaccount.com", got.Email)
+			assert.Equal(t, []string{drive.DriveScope}, got.Scopes)
+			assert.Equal(t, "admin@example.com", got.Subject)
+		}
+	})
 }
 
 func TestOAuthCallbackServer(t *testing.T) {
-	const given = "want"
+	const (
+		given = "want"
+		state = "state-1234"
+	)
 	want := given
 
 	for _, tc := range []struct {
 		description string
 		query       string
 		success     bool
+		status      int
 		err         error
 	}{
 		{
 			description: "success",
-			query:       fmt.Sprintf("?code=%s", want),
+			query:       fmt.Sprintf("?code=%s&state=%s", want, state),
 			success:     true,
+			status:      http.StatusOK,
 			err:         nil,
 		},
 		{
 			description: "empty param",
-			query:       "",
+			query:       "?state=" + state,
+			success:     false,
+			status:      http.StatusBadRequest,
+			err:         errors.New("invalid callback params state=state-1234\n"),
+		},
+		{
+			description: "access denied",
+			query:       "?error=access_denied&state=" + state,
 			success:     false,
-			err:         errors.New("invalid callback params \n"),
+			status:      http.StatusForbidden,
+			err:         errAccessDenied,
 		},
 	} {
 		t.Run(tc.description, func(t *testing.T) {
-			ln, err := net.Listen("tcp", ":0")
+			ln, err := listenLoopback(0)
 			assert.NoError(t, err)
 
-			addr := strings.Split(ln.Addr().String(), "]")[1]
+			redirect, err := callbackURL(ln)
+			assert.NoError(t, err)
+			pages := make(chan string, 1)
 			go func() {
 				time.Sleep(500 * time.Millisecond)
-				reqUrl := fmt.Sprintf("http://localhost%s/auth/callback/%s", addr, tc.query)
+				reqUrl := redirect + tc.query
 				req, err := http.NewRequest("GET", reqUrl, nil)
 				assert.NoError(t, err)
 
@@ -166,15 +208,192 @@ func TestOAuthCallbackServer(t *testing.T) {
 					err := resp.Body.Close()
 					assert.NoError(t, err)
 				}()
+				assert.Equal(t, tc.status, resp.StatusCode)
+				page, err := ioutil.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				pages <- string(page)
 			}()
 
-			actual, err := GetTokenWithBrowser(ln)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			actual, err := GetTokenWithBrowser(ctx, ln, state)
 			if tc.success {
 				assert.NoError(t, err)
 			} else {
-				assert.Error(t, err)
+				assert.EqualError(t, err, tc.err.Error())
 			}
 			assert.Equal(t, tc.success, actual == want)
+
+			page := <-pages
+			if tc.err == errAccessDenied {
+				assert.Contains(t, page, "Access denied")
+			}
+		})
+	}
+
+	t.Run("wrong state", func(t *testing.T) {
+		ln, err := listenLoopback(0)
+		assert.NoError(t, err)
+		redirect, err := callbackURL(ln)
+		assert.NoError(t, err)
+
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			for _, query := range []string{"?code=forged&state=other", "?code=" + want + "&state=" + state} {
+				resp, err := http.Get(redirect + query)
+				if assert.NoError(t, err) {
+					_ = resp.Body.Close()
+				}
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		actual, err := GetTokenWithBrowser(ctx, ln, state)
+		assert.NoError(t, err, "a stray callback should not end the sign in")
+		assert.Equal(t, want, actual)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ln, err := listenLoopback(0)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		actual, err := GetTokenWithBrowser(ctx, ln, state)
+		assert.EqualError(t, err, "waiting for the browser sign in: context deadline exceeded")
+		assert.Empty(t, actual)
+
+		_, err = net.Dial("tcp", ln.Addr().String())
+		assert.Error(t, err, "the callback server should be shut down")
+	})
+
+	t.Run("loopback only", func(t *testing.T) {
+		ln, err := listenLoopback(0)
+		assert.NoError(t, err)
+		defer func() {
+			_ = ln.Close()
+		}()
+		host, port, err := net.SplitHostPort(ln.Addr().String())
+		assert.NoError(t, err)
+		assert.True(t, net.ParseIP(host).IsLoopback(), host)
+
+		redirect, err := callbackURL(ln)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("http://%s/auth/callback/", net.JoinHostPort(host, port)), redirect)
+	})
+
+	t.Run("fixed port", func(t *testing.T) {
+		free, err := listenLoopback(0)
+		assert.NoError(t, err)
+		_, port, err := net.SplitHostPort(free.Addr().String())
+		assert.NoError(t, err)
+		assert.NoError(t, free.Close())
+
+		n, err := strconv.Atoi(port)
+		assert.NoError(t, err)
+		ln, err := listenLoopback(n)
+		if assert.NoError(t, err) {
+			_, got, err := net.SplitHostPort(ln.Addr().String())
+			assert.NoError(t, err)
+			assert.Equal(t, port, got)
+
+			_, err = listenLoopback(n)
+			assert.Error(t, err, "the port is taken")
+			_ = ln.Close()
+		}
+	})
+}
+
+func TestGetBaseFolder(t *testing.T) {
+	service, backend, _ := setupBackend(t)
+	backend.PageSize = 2
+
+	// media folders of early releases, tagged as root and listed first
+	for i := 0; i < 3; i++ {
+		_, err := backend.CreateFolder(service.ctx, &drive.File{
+			Name:       "legacy.bin",
+			Properties: map[string]string{"udsRoot": "true", "size_numeric": "10", "md5": "md5-1"},
 		})
+		assert.NoError(t, err)
+	}
+	root, err := backend.CreateFolder(service.ctx, &drive.File{
+		Name:       "UDS Root",
+		Properties: map[string]string{"udsRoot": "true"},
+	})
+	assert.NoError(t, err)
+
+	got, err := service.GetBaseFolder()
+	assert.NoError(t, err)
+	assert.Equal(t, root.Id, got.Id, "the root past the first page should be found")
+}
+
+func TestListFiles(t *testing.T) {
+	setup := func(t *testing.T, count int) *Service {
+		service, backend, _ := setupBackend(t)
+		backend.PageSize = 2
+
+		for i := 0; i < count; i++ {
+			media := uds.NewFile(fmt.Sprintf("file-%d", i), "", int64(i+1), "", nil)
+			_, err := service.CreateMediaFolder(media)
+			assert.NoError(t, err)
+		}
+		return service
 	}
+
+	t.Run("follow page tokens", func(t *testing.T) {
+		service := setup(t, 5)
+
+		files, err := service.ListFiles("")
+		assert.NoError(t, err)
+		if assert.Len(t, files, 5) {
+			for i, f := range files {
+				assert.Equal(t, fmt.Sprintf("file-%d", i), f.Name)
+				assert.Equal(t, fmt.Sprint(i+1), f.SizeNumeric)
+				assert.NotEmpty(t, f.ID)
+			}
+		}
+	})
+
+	t.Run("stop early", func(t *testing.T) {
+		service := setup(t, 5)
+
+		var got []*uds.File
+		err := service.ListFilesIter(service.ctx, ListOptions{}, func(f *uds.File) error {
+			got = append(got, f)
+			if len(got) == 3 {
+				return ErrStopIteration
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, got, 3)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		service := setup(t, 1)
+
+		given := errors.New("callback error")
+		err := service.ListFilesIter(service.ctx, ListOptions{}, func(*uds.File) error {
+			return given
+		})
+		assert.Equal(t, given, err)
+	})
+}
+
+func setupBackend(t *testing.T) (*Service, *drivetest.Backend, *afero.Afero) {
+	fsBackup := AppFs
+	AppFs = afero.NewMemMapFs()
+	t.Cleanup(func() {
+		AppFs = fsBackup
+	})
+
+	backend := drivetest.NewBackend(afero.NewMemMapFs())
+	return NewServiceWithBackend(backend), backend, &afero.Afero{Fs: AppFs}
+}
+
+func randomBytes(n int64) []byte {
+	b := make([]byte, n)
+	_, _ = rand.New(rand.NewSource(n)).Read(b)
+	return b
 }
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Backend is the storage holding UDS folders and chunk Docs. Queries given to
+// List follow the Google Drive v3 search syntax.
+type Backend interface {
+	CreateFolder(ctx context.Context, file *drive.File) (*drive.File, error)
+	CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error)
+	List(ctx context.Context, q, pageToken string) (*drive.FileList, error)
+	Get(ctx context.Context, id string) (*drive.File, error)
+	Export(ctx context.Context, id string) (string, error)
+	Delete(ctx context.Context, id string) error
+	UpdateProperties(ctx context.Context, id string, properties map[string]string) (*drive.File, error)
+	SetTrashed(ctx context.Context, id string, trashed bool) (*drive.File, error)
+	// Move takes the file id out of the folders from and puts it in to
+	Move(ctx context.Context, id string, from []string, to string) (*drive.File, error)
+	About(ctx context.Context) (*drive.About, error)
+	// GenerateIDs returns count ids that files may be created with, so that a
+	// create can be retried without making the file twice
+	GenerateIDs(ctx context.Context, count int) ([]string, error)
+}
+
+const (
+	fileFields     = "id, name, mimeType, parents, properties, trashed, shared, createdTime, modifiedTime"
+	listFields     = "nextPageToken, files(" + fileFields + ")"
+	listPageSize   = 1000
+	exportMimeType = "text/plain"
+)
+
+// NewDriveBackend function returns Backend working on the given Drive service
+func NewDriveBackend(srv *drive.Service) Backend {
+	return &driveBackend{files: srv.Files, about: srv.About}
+}
+
+type driveBackend struct {
+	files *drive.FilesService
+	about *drive.AboutService
+}
+
+func (b *driveBackend) CreateFolder(ctx context.Context, file *drive.File) (*drive.File, error) {
+	return b.files.Create(file).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	return b.files.Create(file).
+		Media(strings.NewReader(content), googleapi.ContentType(exportMimeType)).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) List(ctx context.Context, q, pageToken string) (*drive.FileList, error) {
+	return b.files.List().
+		Q(q).
+		PageSize(listPageSize).
+		PageToken(pageToken).
+		Context(ctx).
+		Fields(listFields).Do()
+}
+
+func (b *driveBackend) Get(ctx context.Context, id string) (*drive.File, error) {
+	return b.files.Get(id).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) Export(ctx context.Context, id string) (string, error) {
+	resp, err := b.files.Export(id, exportMimeType).Context(ctx).Download()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (b *driveBackend) Delete(ctx context.Context, id string) error {
+	return b.files.Delete(id).Context(ctx).Do()
+}
+
+func (b *driveBackend) UpdateProperties(ctx context.Context, id string, properties map[string]string) (*drive.File, error) {
+	return b.files.Update(id, &drive.File{Properties: properties}).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) SetTrashed(ctx context.Context, id string, trashed bool) (*drive.File, error) {
+	return b.files.Update(id, &drive.File{Trashed: trashed, ForceSendFields: []string{"Trashed"}}).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) Move(ctx context.Context, id string, from []string, to string) (*drive.File, error) {
+	return b.files.Update(id, &drive.File{}).
+		AddParents(to).
+		RemoveParents(strings.Join(from, ",")).
+		Context(ctx).
+		Fields(fileFields).Do()
+}
+
+func (b *driveBackend) About(ctx context.Context) (*drive.About, error) {
+	return b.about.Get().
+		Context(ctx).
+		Fields("user, storageQuota").Do()
+}
+
+func (b *driveBackend) GenerateIDs(ctx context.Context, count int) ([]string, error) {
+	r, err := b.files.GenerateIds().
+		Count(int64(count)).
+		Space("drive").
+		Context(ctx).
+		Fields("ids").Do()
+	if err != nil {
+		return nil, err
+	}
+	return r.Ids, nil
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func setupServer(t *testing.T) (*Service, *drivetest.Server, *afero.Afero) {
+	fsBackup := AppFs
+	AppFs = afero.NewMemMapFs()
+
+	srv := drivetest.NewServer(afero.NewMemMapFs())
+	t.Cleanup(func() {
+		srv.Close()
+		AppFs = fsBackup
+	})
+
+	service, err := NewServiceWithOptions(context.Background(),
+		option.WithEndpoint(srv.URL+"/"),
+		option.WithHTTPClient(srv.Client()),
+	)
+	assert.NoError(t, err)
+	return service, srv, &afero.Afero{Fs: AppFs}
+}
+
+func TestDriveBackend(t *testing.T) {
+	t.Run("upload and download", func(t *testing.T) {
+		service, srv, afs := setupServer(t)
+
+		data := randomBytes(uds.ChunkReadLengthBytes + 42)
+		assert.NoError(t, afs.WriteFile("/backup.tar", data, 0600))
+
+		media, err := service.Upload(service.ctx, "/backup.tar", "")
+		assert.NoError(t, err)
+
+		folder, err := srv.Backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, media.MD5, folder.Properties["md5"])
+
+		root, err := service.GetBaseFolder()
+		assert.NoError(t, err)
+		assert.Equal(t, media.Parents, []string{root.Id})
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/restored.tar"))
+		got, err := afs.ReadFile("/restored.tar")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("stream and random access", func(t *testing.T) {
+		service, _, _ := setupServer(t)
+
+		data := randomBytes(2*uds.ChunkReadLengthBytes + 1)
+		w, err := service.Create(service.ctx, "dump.sql")
+		assert.NoError(t, err)
+		_, err = io.Copy(w, bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		id := w.(*writer).media.ID
+
+		r, err := service.Open(service.ctx, id)
+		assert.NoError(t, err)
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		ra, err := service.NewReader(service.ctx, id)
+		assert.NoError(t, err)
+		p := make([]byte, 2)
+		_, err = ra.ReadAt(p, 2*uds.ChunkReadLengthBytes-1)
+		assert.NoError(t, err)
+		assert.Equal(t, data[2*uds.ChunkReadLengthBytes-1:], p)
+	})
+
+	t.Run("trash and restore", func(t *testing.T) {
+		service, _, afs := setupServer(t)
+
+		assert.NoError(t, afs.WriteFile("/notes.txt", []byte("notes"), 0600))
+		media, err := service.Upload(service.ctx, "/notes.txt", "")
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.Delete(service.ctx, media.ID, false))
+		got, err := service.GetFile(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.True(t, got.Trashed)
+
+		assert.NoError(t, service.Restore(service.ctx, media.ID))
+		got, err = service.GetFile(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.False(t, got.Trashed)
+		assert.Equal(t, media.MD5, got.MD5, "properties should be kept")
+
+		assert.NoError(t, service.Delete(service.ctx, media.ID, true))
+		_, err = service.GetFile(service.ctx, media.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		service, srv, _ := setupServer(t)
+		ctx := service.ctx
+
+		from, err := service.backend.CreateFolder(ctx, &drive.File{Name: "from"})
+		assert.NoError(t, err)
+		to, err := service.backend.CreateFolder(ctx, &drive.File{Name: "to"})
+		assert.NoError(t, err)
+		doc, err := service.backend.CreateDoc(ctx, &drive.File{Name: "doc", Parents: []string{from.Id}}, "content")
+		assert.NoError(t, err)
+
+		got, err := service.backend.Move(ctx, doc.Id, []string{from.Id}, to.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{to.Id}, got.Parents)
+
+		stored, err := srv.Backend.Get(ctx, doc.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{to.Id}, stored.Parents)
+	})
+
+	t.Run("generated ids", func(t *testing.T) {
+		service, srv, _ := setupServer(t)
+		ctx := service.ctx
+
+		ids, err := service.backend.GenerateIDs(ctx, 3)
+		assert.NoError(t, err)
+		assert.Len(t, ids, 3)
+
+		doc, err := service.backend.CreateDoc(ctx, &drive.File{Id: ids[1], Name: "doc"}, "content")
+		assert.NoError(t, err)
+		assert.Equal(t, ids[1], doc.Id)
+		_, err = srv.Backend.Get(ctx, ids[1])
+		assert.NoError(t, err)
+	})
+
+	t.Run("about", func(t *testing.T) {
+		service, _, _ := setupServer(t)
+
+		about, err := service.About(service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "Drive Test", about.User.DisplayName)
+	})
+
+	t.Run("errors from drive", func(t *testing.T) {
+		service, _, _ := setupServer(t)
+
+		assert.Error(t, service.Download(service.ctx, "unknown", "/out"))
+		_, err := service.backend.Export(service.ctx, "unknown")
+		assert.Error(t, err)
+		_, err = service.backend.UpdateProperties(service.ctx, "unknown", nil)
+		assert.Error(t, err)
+		assert.Error(t, service.backend.Delete(service.ctx, "unknown"))
+	})
+}
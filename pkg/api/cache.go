@@ -0,0 +1,52 @@
+package api
+
+import "container/list"
+
+// chunkCache keeps the most recently used decoded chunks
+type chunkCache struct {
+	capacity int
+	ll       *list.List
+	items    map[int64]*list.Element
+}
+
+type cacheEntry struct {
+	part int64
+	data []byte
+}
+
+func newChunkCache(capacity int) *chunkCache {
+	return &chunkCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[int64]*list.Element),
+	}
+}
+
+func (c *chunkCache) get(part int64) ([]byte, bool) {
+	e, ok := c.items[part]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (c *chunkCache) add(part int64, data []byte) {
+	if e, ok := c.items[part]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*cacheEntry).data = data
+		return
+	}
+
+	c.items[part] = c.ll.PushFront(&cacheEntry{part: part, data: data})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).part)
+	}
+}
+
+func (c *chunkCache) clear() {
+	c.ll.Init()
+	c.items = make(map[int64]*list.Element)
+}
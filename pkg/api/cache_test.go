@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkCache(t *testing.T) {
+	c := newChunkCache(2)
+
+	_, ok := c.get(0)
+	assert.False(t, ok)
+
+	c.add(0, []byte("zero"))
+	c.add(1, []byte("one"))
+
+	got, ok := c.get(0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("zero"), got)
+
+	c.add(2, []byte("two"))
+
+	_, ok = c.get(1)
+	assert.False(t, ok, "least recently used chunk should be evicted")
+
+	got, ok = c.get(0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("zero"), got)
+
+	c.add(2, []byte("TWO"))
+	got, ok = c.get(2)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("TWO"), got)
+	assert.Equal(t, 2, c.ll.Len())
+
+	c.clear()
+	_, ok = c.get(0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.ll.Len())
+}
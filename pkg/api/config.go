@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Environment variables locating the credentials and the token, when they are
+// not given to the Service. Both take a path or the JSON itself.
+const (
+	CredentialsEnv = "UDS_CREDENTIALS"
+	TokenEnv       = "UDS_TOKEN"
+)
+
+// DefaultConfigDir function returns $XDG_CONFIG_HOME/uds, or ~/.config/uds
+// when the variable is not set
+func DefaultConfigDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "uds"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "uds"), nil
+}
+
+// credentials returns the JSON of the OAuth client or of the service account
+// key, from CredentialsFile, UDS_CREDENTIALS or the config directory in turn
+func (api *Service) credentials() ([]byte, error) {
+	path := api.CredentialsFile
+	if path == "" {
+		env := os.Getenv(CredentialsEnv)
+		if isInlineJSON(env) {
+			return []byte(env), nil
+		}
+		path = env
+	}
+	if path == "" {
+		dir, err := DefaultConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, credentialFile)
+	}
+
+	afs := &afero.Afero{Fs: AppFs}
+	b, err := afs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no credentials at %s, give them with %s or put them there", path, CredentialsEnv)
+	}
+	return b, err
+}
+
+// tokenLocation returns the path the OAuth token is kept at, from TokenFile,
+// UDS_TOKEN or the config directory in turn. The token itself is returned
+// instead when UDS_TOKEN holds its JSON.
+func (api *Service) tokenLocation() (path string, inline []byte, err error) {
+	if api.TokenFile != "" {
+		return api.TokenFile, nil, nil
+	}
+	env := os.Getenv(TokenEnv)
+	if isInlineJSON(env) {
+		return "", []byte(env), nil
+	}
+	if env != "" {
+		return env, nil, nil
+	}
+
+	dir, err := DefaultConfigDir()
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(dir, tokenFile), nil, nil
+}
+
+// isInlineJSON tells whether the value of an environment variable is a JSON
+// object rather than a path
+func isInlineJSON(v string) bool {
+	return strings.HasPrefix(strings.TrimSpace(v), "{")
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// setEnv sets the environment variable key, unset when value is empty, until
+// the end of the test
+func setEnv(t *testing.T, key, value string) {
+	old, found := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if found {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+	if value == "" {
+		assert.NoError(t, os.Unsetenv(key))
+		return
+	}
+	assert.NoError(t, os.Setenv(key, value))
+}
+
+func TestDefaultConfigDir(t *testing.T) {
+	setEnv(t, "XDG_CONFIG_HOME", "/xdg")
+	dir, err := DefaultConfigDir()
+	assert.NoError(t, err)
+	assert.Equal(t, "/xdg/uds", dir)
+
+	setEnv(t, "XDG_CONFIG_HOME", "")
+	setEnv(t, "HOME", "/home/uds")
+	dir, err = DefaultConfigDir()
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/uds/.config/uds", dir)
+}
+
+func TestCredentialsLocation(t *testing.T) {
+	const inline = `{"installed": {"client_id": "inline"}}`
+
+	setup := func(t *testing.T) *afero.Afero {
+		fsBackup := AppFs
+		AppFs = afero.NewMemMapFs()
+		t.Cleanup(func() {
+			AppFs = fsBackup
+		})
+		setEnv(t, "XDG_CONFIG_HOME", "/config")
+		setEnv(t, CredentialsEnv, "")
+		setEnv(t, TokenEnv, "")
+
+		afs := &afero.Afero{Fs: AppFs}
+		for path, content := range map[string]string{
+			"/config/uds/credentials.json": "config",
+			"/env/credentials.json":        "env",
+			"/option/credentials.json":     "option",
+		} {
+			assert.NoError(t, afs.WriteFile(path, []byte(content), 0600))
+		}
+		return afs
+	}
+
+	for _, tc := range []struct {
+		description string
+		option      string
+		env         string
+		want        string
+	}{
+		{description: "config directory", want: "config"},
+		{description: "env path", env: "/env/credentials.json", want: "env"},
+		{description: "inline env", env: "\n" + inline, want: "\n" + inline},
+		{description: "option first", option: "/option/credentials.json", env: inline, want: "option"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			setup(t)
+			setEnv(t, CredentialsEnv, tc.env)
+
+			service := &Service{CredentialsFile: tc.option}
+			got, err := service.credentials()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		setup(t)
+		setEnv(t, CredentialsEnv, "/nowhere.json")
+
+		_, err := (&Service{}).credentials()
+		assert.EqualError(t, err, "no credentials at /nowhere.json, give them with UDS_CREDENTIALS or put them there")
+	})
+
+	t.Run("token", func(t *testing.T) {
+		setup(t)
+
+		path, inline, err := (&Service{}).tokenLocation()
+		assert.NoError(t, err)
+		assert.Equal(t, "/config/uds/token.json", path)
+		assert.Nil(t, inline)
+
+		setEnv(t, TokenEnv, "/env/token.json")
+		path, _, err = (&Service{}).tokenLocation()
+		assert.NoError(t, err)
+		assert.Equal(t, "/env/token.json", path)
+
+		path, _, err = (&Service{TokenFile: "/option/token.json"}).tokenLocation()
+		assert.NoError(t, err)
+		assert.Equal(t, "/option/token.json", path)
+
+		setEnv(t, TokenEnv, `{"access_token": "access-1234"}`)
+		path, inline, err = (&Service{}).tokenLocation()
+		assert.NoError(t, err)
+		assert.Empty(t, path)
+		assert.Equal(t, `{"access_token": "access-1234"}`, string(inline))
+	})
+
+	t.Run("inline credentials and token", func(t *testing.T) {
+		setup(t)
+		helperBackup := Helper
+		t.Cleanup(func() {
+			Helper = helperBackup
+		})
+		Helper.GetToken = func(*oauth2.Config, string) (*oauth2.Token, error) {
+			return nil, errors.New("inline tokens are not read from files")
+		}
+
+		setEnv(t, CredentialsEnv, `{"installed": {"client_id": "client-1234", "client_secret": "secret",
+			"auth_uri": "https://accounts.google.com/o/oauth2/auth",
+			"token_uri": "https://oauth2.googleapis.com/token",
+			"redirect_uris": ["http://localhost"]}}`)
+		setEnv(t, TokenEnv, `{"access_token": "access-1234", "token_type": "Bearer"}`)
+
+		service := &Service{}
+		assert.NoError(t, service.Init())
+
+		setEnv(t, TokenEnv, `{"access_token": `)
+		assert.Error(t, service.Init())
+	})
+}
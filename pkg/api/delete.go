@@ -0,0 +1,96 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// Delete moves the UDS file id to the trash along with every chunk Doc of
+// its media folder. With hard set, they are removed permanently instead.
+func (api *Service) Delete(ctx context.Context, id string, hard bool) error {
+	if _, _, err := api.getMedia(ctx, id); err != nil {
+		return err
+	}
+
+	docs, err := api.chunkDocs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if hard {
+			err = api.backend.Delete(ctx, doc.Id)
+		} else if !doc.Trashed {
+			_, err = api.backend.SetTrashed(ctx, doc.Id, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if hard {
+		return api.backend.Delete(ctx, id)
+	}
+	_, err = api.backend.SetTrashed(ctx, id, true)
+	return err
+}
+
+// Restore takes the UDS file id and its chunk Docs back out of the trash
+func (api *Service) Restore(ctx context.Context, id string) error {
+	if _, _, err := api.getMedia(ctx, id); err != nil {
+		return err
+	}
+
+	docs, err := api.chunkDocs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if !doc.Trashed {
+			continue
+		}
+		if _, err := api.backend.SetTrashed(ctx, doc.Id, false); err != nil {
+			return err
+		}
+	}
+
+	_, err = api.backend.SetTrashed(ctx, id, false)
+	return err
+}
+
+// EmptyTrash permanently deletes every UDS file in the trash, leaving other
+// trashed Drive files alone. It returns the number of deleted files.
+func (api *Service) EmptyTrash(ctx context.Context) (int, error) {
+	var ids []string
+	q := NewQuery().Property(uds.MediaProperty, "true").Trashed(true)
+	err := api.listAll(ctx, q.String(), func(f *drive.File) error {
+		ids = append(ids, f.Id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := api.Delete(ctx, id, true); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// chunkDocs returns every Doc inside a media folder, trashed or not
+func (api *Service) chunkDocs(ctx context.Context, folderID string) ([]*drive.File, error) {
+	var docs []*drive.File
+	err := api.listAll(ctx, NewQuery().Parent(folderID).String(), func(f *drive.File) error {
+		docs = append(docs, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
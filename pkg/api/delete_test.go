@@ -0,0 +1,114 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestDelete(t *testing.T) {
+	setup := func(t *testing.T) (*Service, *uds.File) {
+		service, _, afs := setupBackend(t)
+
+		data := randomBytes(uds.ChunkReadLengthBytes + 1)
+		assert.NoError(t, afs.WriteFile("/data.bin", data, 0600))
+		media, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		return service, media
+	}
+
+	docs := func(t *testing.T, service *Service, id string) []*drive.File {
+		docs, err := service.chunkDocs(service.ctx, id)
+		assert.NoError(t, err)
+		return docs
+	}
+
+	t.Run("trash and restore", func(t *testing.T) {
+		service, media := setup(t)
+
+		assert.NoError(t, service.Delete(service.ctx, media.ID, false))
+
+		files, err := service.ListFiles("")
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+
+		var trashed []*uds.File
+		err = service.ListFilesIter(service.ctx, ListOptions{IncludeTrashed: true}, func(f *uds.File) error {
+			trashed = append(trashed, f)
+			return nil
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, trashed, 1) {
+			assert.True(t, trashed[0].Trashed)
+		}
+
+		chunks := docs(t, service, media.ID)
+		assert.Len(t, chunks, 2)
+		for _, doc := range chunks {
+			assert.True(t, doc.Trashed)
+		}
+
+		assert.NoError(t, service.Restore(service.ctx, media.ID))
+
+		got, err := service.GetFile(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.False(t, got.Trashed)
+		for _, doc := range docs(t, service, media.ID) {
+			assert.False(t, doc.Trashed)
+		}
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/restored.bin"))
+	})
+
+	t.Run("hard delete", func(t *testing.T) {
+		service, media := setup(t)
+
+		assert.NoError(t, service.Delete(service.ctx, media.ID, true))
+
+		_, err := service.GetFile(service.ctx, media.ID)
+		assert.Error(t, err)
+		assert.Empty(t, docs(t, service, media.ID))
+	})
+
+	t.Run("only media folders", func(t *testing.T) {
+		service, _ := setup(t)
+
+		root, err := service.GetBaseFolder()
+		assert.NoError(t, err)
+
+		assert.Error(t, service.Delete(service.ctx, root.Id, false))
+		assert.Error(t, service.Delete(service.ctx, root.Id, true))
+		assert.Error(t, service.Restore(service.ctx, root.Id))
+		assert.Error(t, service.Delete(service.ctx, "unknown", false))
+	})
+
+	t.Run("empty trash", func(t *testing.T) {
+		service, media := setup(t)
+
+		assert.NoError(t, AppFs.Rename("/data.bin", "/kept.bin"))
+		kept, err := service.Upload(service.ctx, "/kept.bin", "")
+		assert.NoError(t, err)
+
+		other, err := service.backend.CreateFolder(service.ctx, &drive.File{Name: "not uds"})
+		assert.NoError(t, err)
+		_, err = service.backend.SetTrashed(service.ctx, other.Id, true)
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.Delete(service.ctx, media.ID, false))
+
+		n, err := service.EmptyTrash(service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		_, err = service.GetFile(service.ctx, media.ID)
+		assert.Error(t, err)
+		assert.Empty(t, docs(t, service, media.ID))
+
+		_, err = service.GetFile(service.ctx, kept.ID)
+		assert.NoError(t, err)
+		_, err = service.backend.Get(service.ctx, other.Id)
+		assert.NoError(t, err, "trashed files outside of UDS should be kept")
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	// GoogleDeviceAuthURL is the device authorization endpoint of Google
+	GoogleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+	deviceGrantType       = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultDeviceInterval = 5 * time.Second
+	slowDownIncrement     = 5 * time.Second
+)
+
+var (
+	errDeviceAccessDenied = errors.New("device authorization denied")
+	errDeviceCodeExpired  = errors.New("device code expired before it was entered")
+	errDeviceScope        = errors.New("device authorization: the scope was refused, " +
+		"the device flow only grants access to the files created by UDS")
+)
+
+// deviceCode is the answer of a device authorization endpoint
+type deviceCode struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code"`
+	// Google answers with verification_url, RFC 8628 with verification_uri
+	VerificationURL string `json:"verification_url"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Interval        int64  `json:"interval"`
+
+	Error string `json:"error"`
+}
+
+// tokenResponse is the answer of a token endpoint, either a token or an error
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func getTokenFromDevice(config *oauth2.Config) (*oauth2.Token, error) {
+	return deviceToken(context.Background(), config, Helper.DeviceAuthURL, Helper.Clock, func(code *deviceCode) {
+		fmt.Printf("Go to the following link in your browser, then enter the code %s\n%v\n\n",
+			code.UserCode, code.verificationURL())
+	})
+}
+
+// deviceToken gets a token through the OAuth device authorization flow: the
+// user enters the code shown by prompt on another device, while the token
+// endpoint is polled until the authorization is granted, denied or expired.
+// The OAuth client must be of the "TVs and Limited Input devices" type, and
+// Google grants it a few scopes only: see deviceScopes.
+func deviceToken(
+	ctx context.Context, config *oauth2.Config, deviceAuthURL string, clock Clock, prompt func(*deviceCode),
+) (*oauth2.Token, error) {
+	if clock == nil {
+		clock = wallClock{}
+	}
+
+	var code deviceCode
+	err := postForm(ctx, deviceAuthURL, url.Values{
+		"client_id": {config.ClientID},
+		"scope":     {strings.Join(deviceScopes(config.Scopes), " ")},
+	}, &code)
+	if code.Error == "invalid_scope" {
+		return nil, errDeviceScope
+	}
+	if err != nil {
+		return nil, fmt.Errorf("device authorization: %v", err)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return nil, errors.New("device authorization: no device code in the answer")
+	}
+	prompt(&code)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceInterval
+	}
+	var deadline time.Time
+	if code.ExpiresIn > 0 {
+		deadline = clock.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	}
+
+	for {
+		if err := clock.Sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+		if !deadline.IsZero() && clock.Now().After(deadline) {
+			return nil, errDeviceCodeExpired
+		}
+
+		var resp tokenResponse
+		err := postForm(ctx, config.Endpoint.TokenURL, url.Values{
+			"client_id":     {config.ClientID},
+			"client_secret": {config.ClientSecret},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {deviceGrantType},
+		}, &resp)
+		if err != nil && resp.Error == "" {
+			return nil, err
+		}
+
+		switch resp.Error {
+		case "":
+			return resp.token(clock.Now()), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownIncrement
+		case "access_denied":
+			return nil, errDeviceAccessDenied
+		case "expired_token":
+			return nil, errDeviceCodeExpired
+		default:
+			if resp.ErrorDescription != "" {
+				return nil, fmt.Errorf("device token: %s: %s", resp.Error, resp.ErrorDescription)
+			}
+			return nil, fmt.Errorf("device token: %s", resp.Error)
+		}
+	}
+}
+
+// deviceScopes returns scopes with the full Drive scope, which Google does
+// not allow for the device flow, narrowed to drive.file: the files created
+// or opened by the app, which are all the files UDS touches.
+func deviceScopes(scopes []string) []string {
+	narrowed := make([]string, len(scopes))
+	for i, scope := range scopes {
+		if scope == drive.DriveScope {
+			scope = drive.DriveFileScope
+		}
+		narrowed[i] = scope
+	}
+	return narrowed
+}
+
+func (c *deviceCode) verificationURL() string {
+	if c.VerificationURL != "" {
+		return c.VerificationURL
+	}
+	return c.VerificationURI
+}
+
+func (r *tokenResponse) token(now time.Time) *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		RefreshToken: r.RefreshToken,
+	}
+	if r.ExpiresIn > 0 {
+		token.Expiry = now.Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return token
+}
+
+// postForm posts form to endpoint and decodes the JSON answer into v, which
+// is decoded from error answers as well
+func postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
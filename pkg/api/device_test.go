@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeTokenServer stands in for the device authorization and token endpoints,
+// answering the polls of the token endpoint with the queued errors first
+type fakeTokenServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	errs  []string
+	polls int
+}
+
+func newFakeTokenServer(t *testing.T, errs ...string) *fakeTokenServer {
+	s := &fakeTokenServer{errs: errs}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device/code", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client-1", r.PostForm.Get("client_id"))
+		assert.Equal(t, drive.DriveFileScope, r.PostForm.Get("scope"), "drive is not allowed for the device flow")
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"device_code":      "device-1234",
+			"user_code":        "ABCD-EFGH",
+			"verification_url": "https://www.google.com/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, deviceGrantType, r.PostForm.Get("grant_type"))
+		assert.Equal(t, "device-1234", r.PostForm.Get("device_code"))
+		assert.Equal(t, "secret-2", r.PostForm.Get("client_secret"))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.polls++
+		if len(s.errs) > 0 {
+			code := s.errs[0]
+			s.errs = s.errs[1:]
+			status := http.StatusBadRequest
+			if code == "authorization_pending" {
+				status = http.StatusPreconditionRequired
+			}
+			writeJSON(w, status, map[string]string{"error": code})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token":  "access-1234",
+			"token_type":    "Bearer",
+			"refresh_token": "refresh-1234",
+			"expires_in":    3600,
+		})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeTokenServer) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client-1",
+		ClientSecret: "secret-2",
+		Endpoint:     oauth2.Endpoint{TokenURL: s.URL + "/token"},
+		Scopes:       []string{drive.DriveScope},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestDeviceToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("poll until authorized", func(t *testing.T) {
+		srv := newFakeTokenServer(t, "authorization_pending", "slow_down", "authorization_pending")
+		clock := &fakeClock{now: time.Unix(0, 0)}
+
+		var prompted *deviceCode
+		token, err := deviceToken(ctx, srv.config(), srv.URL+"/device/code", clock, func(code *deviceCode) {
+			prompted = code
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "access-1234", token.AccessToken)
+		assert.Equal(t, "refresh-1234", token.RefreshToken)
+		assert.Equal(t, time.Unix(0, 0).Add(14*time.Second+time.Hour), token.Expiry)
+
+		if assert.NotNil(t, prompted) {
+			assert.Equal(t, "ABCD-EFGH", prompted.UserCode)
+			assert.Equal(t, "https://www.google.com/device", prompted.verificationURL())
+		}
+		assert.Equal(t, 4, srv.polls)
+		assert.Equal(t, []time.Duration{
+			time.Second, time.Second, 6 * time.Second, 6 * time.Second,
+		}, clock.waits, "slow_down should add 5 seconds to the interval")
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		srv := newFakeTokenServer(t, "authorization_pending", "access_denied")
+
+		_, err := deviceToken(ctx, srv.config(), srv.URL+"/device/code", &fakeClock{}, func(*deviceCode) {})
+		assert.Equal(t, errDeviceAccessDenied, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		pending := make([]string, 100)
+		for i := range pending {
+			pending[i] = "authorization_pending"
+		}
+		srv := newFakeTokenServer(t, pending...)
+
+		_, err := deviceToken(ctx, srv.config(), srv.URL+"/device/code", &fakeClock{}, func(*deviceCode) {})
+		assert.Equal(t, errDeviceCodeExpired, err)
+		assert.Equal(t, 60, srv.polls, "polls should stop once the code expires")
+
+		srv = newFakeTokenServer(t, "expired_token")
+		_, err = deviceToken(ctx, srv.config(), srv.URL+"/device/code", &fakeClock{}, func(*deviceCode) {})
+		assert.Equal(t, errDeviceCodeExpired, err)
+	})
+
+	t.Run("unexpected errors", func(t *testing.T) {
+		srv := newFakeTokenServer(t, "invalid_client")
+		_, err := deviceToken(ctx, srv.config(), srv.URL+"/device/code", &fakeClock{}, func(*deviceCode) {})
+		assert.EqualError(t, err, "device token: invalid_client")
+
+		_, err = deviceToken(ctx, srv.config(), srv.URL+"/unknown", &fakeClock{}, func(*deviceCode) {})
+		assert.Error(t, err)
+	})
+
+	t.Run("scope refused", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+		}))
+		t.Cleanup(srv.Close)
+
+		_, err := deviceToken(ctx, &oauth2.Config{ClientID: "client-1"}, srv.URL, &fakeClock{}, func(*deviceCode) {})
+		assert.Equal(t, errDeviceScope, err)
+	})
+
+	t.Run("helper strategy", func(t *testing.T) {
+		srv := newFakeTokenServer(t)
+		helperBackup := Helper
+		t.Cleanup(func() {
+			Helper = helperBackup
+		})
+		Helper.DeviceAuthURL = srv.URL + "/device/code"
+		Helper.Clock = &fakeClock{}
+		Helper.OpenBrowser = nil
+
+		token, err := Helper.GetTokenFromWeb(srv.config())
+		assert.NoError(t, err)
+		assert.Equal(t, "access-1234", token.AccessToken)
+	})
+}
+
+func TestDeviceScopes(t *testing.T) {
+	assert.Equal(t, []string{drive.DriveFileScope}, deviceScopes([]string{drive.DriveScope}))
+	assert.Equal(t, []string{drive.DriveFileScope, "openid"}, deviceScopes([]string{drive.DriveFileScope, "openid"}))
+}
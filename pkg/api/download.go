@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// Download fetches every chunk Doc of the media folder fileID, reassembles
+// them in part order and writes the result to destPath. The data goes to a
+// temporary file first and only replaces destPath once its MD5 matches.
+func (api *Service) Download(ctx context.Context, fileID, destPath string) error {
+	r, err := api.Open(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	tmp, err := afero.TempFile(AppFs, filepath.Dir(destPath), "."+filepath.Base(destPath)+".uds-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			_ = tmp.Close()
+			_ = AppFs.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+
+	name := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	tmp = nil
+	if err := AppFs.Rename(name, destPath); err != nil {
+		_ = AppFs.Remove(name)
+		return err
+	}
+	return nil
+}
+
+// getMedia returns the media folder fileID along with its metadata
+func (api *Service) getMedia(ctx context.Context, fileID string) (*drive.File, *uds.Metadata, error) {
+	folder, err := api.backend.Get(ctx, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta, err := uds.ParseMetadata(folder.Properties)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s (%s): %v", folder.Name, fileID, err)
+	}
+	return folder, meta, nil
+}
+
+// downloadChunk exports the Doc holding chunk and decodes it, checking it
+// against the checksum recorded on the Doc when there is one
+func (api *Service) downloadChunk(ctx context.Context, chunk *uds.Chunk, doc *drive.File) ([]byte, error) {
+	content, err := api.backend.Export(ctx, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	chunk.Checksum = doc.Properties[uds.SHA256Property]
+	return chunk.Decode(content)
+}
+
+// listChunks returns the chunk Docs of a media folder indexed by their part.
+// Copies of a part with the same checksum are ignored.
+func (api *Service) listChunks(ctx context.Context, folderID string, count int64) ([]*drive.File, error) {
+	chunks := make([]*drive.File, count)
+
+	q := NewQuery().Parent(folderID).Trashed(false)
+	err := api.listAll(ctx, q.String(), func(f *drive.File) error {
+		part, err := uds.ParsePart(f.Properties)
+		if err != nil || part >= int64(len(chunks)) {
+			return fmt.Errorf("unexpected chunk %s (%s) in %s", f.Name, f.Id, folderID)
+		}
+		if prev := chunks[part]; prev != nil {
+			// a create retried after Drive made the Doc leaves an identical copy
+			sum := prev.Properties[uds.SHA256Property]
+			if sum != "" && sum == f.Properties[uds.SHA256Property] {
+				return nil
+			}
+			return fmt.Errorf("duplicated chunk part %d in %s", part, folderID)
+		}
+		chunks[part] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for part, f := range chunks {
+		if f == nil {
+			return nil, fmt.Errorf("missing chunk part %d in %s", part, folderID)
+		}
+	}
+	return chunks, nil
+}
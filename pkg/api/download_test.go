@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestDownload(t *testing.T) {
+	setup := func(t *testing.T, data []byte) (*Service, *uds.File) {
+		service, _, afs := setupBackend(t)
+
+		assert.NoError(t, afs.WriteFile("/src/file.bin", data, 0600))
+		media, err := service.Upload(service.ctx, "/src/file.bin", "")
+		assert.NoError(t, err)
+		return service, media
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, size := range []int64{0, 1, uds.ChunkReadLengthBytes, 2*uds.ChunkReadLengthBytes + 7} {
+			data := randomBytes(size)
+			service, media := setup(t, data)
+
+			assert.NoError(t, AppFs.MkdirAll("/dst", 0700))
+			assert.NoError(t, service.Download(service.ctx, media.ID, "/dst/file.bin"))
+
+			afs := afero.Afero{Fs: AppFs}
+			got, err := afs.ReadFile("/dst/file.bin")
+			assert.NoError(t, err)
+			assert.Equal(t, data, got, size)
+
+			infos, err := afs.ReadDir("/dst")
+			assert.NoError(t, err)
+			assert.Len(t, infos, 1, "temporary file should be renamed")
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+		service.Passphrase = []byte("secret")
+
+		data := randomBytes(uds.ChunkReadLengthBytes + 7)
+		assert.NoError(t, afs.WriteFile("/src/file.bin", data, 0600))
+		media, err := service.Upload(service.ctx, "/src/file.bin", "")
+		assert.NoError(t, err)
+
+		folder, err := backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, uds.CipherAES256GCM, folder.Properties["cipher"])
+		assert.NotEmpty(t, folder.Properties["kdf_salt"])
+
+		docs, err := service.listChunks(service.ctx, media.ID, 2)
+		assert.NoError(t, err)
+		content, err := backend.Export(service.ctx, docs[1].Id)
+		assert.NoError(t, err)
+		_, err = (&uds.Chunk{Part: 1, MaxSize: int64(len(data)), Media: media}).Decode(content)
+		assert.Error(t, err, "chunks should not be readable without the cipher")
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/src/copy.bin"))
+		got, err := afs.ReadFile("/src/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		service.Passphrase = []byte("wrong")
+		assert.Error(t, service.Download(service.ctx, media.ID, "/src/wrong.bin"))
+
+		service.Passphrase = nil
+		err = service.Download(service.ctx, media.ID, "/src/none.bin")
+		assert.EqualError(t, err, "file.bin ("+media.ID+"): "+uds.ErrPassphraseRequired.Error())
+
+		exists, err := afs.Exists("/src/wrong.bin")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+		service.Compression = uds.CompressionZstd
+
+		text := bytes.Repeat([]byte("compress me\n"), int(uds.ChunkReadLengthBytes/6))
+		assert.NoError(t, afs.WriteFile("/src/log.txt", text, 0600))
+		assert.NoError(t, afs.WriteFile("/src/photo.jpg", text, 0600))
+
+		media, err := service.Upload(service.ctx, "/src/log.txt", "")
+		assert.NoError(t, err)
+		folder, err := backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "zstd", folder.Properties["compression"])
+
+		r, err := backend.List(service.ctx, "'"+media.ID+"' in parents", "")
+		assert.NoError(t, err)
+		assert.Len(t, r.Files, 2)
+		content, err := backend.Export(service.ctx, r.Files[0].Id)
+		assert.NoError(t, err)
+		assert.True(t, int64(len(content)) < uds.ChunkReadLengthBytes/10)
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/src/copy.txt"))
+		got, err := afs.ReadFile("/src/copy.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, text, got)
+
+		photo, err := service.Upload(service.ctx, "/src/photo.jpg", "")
+		assert.NoError(t, err)
+		folder, err = backend.Get(service.ctx, photo.ID)
+		assert.NoError(t, err)
+		assert.NotContains(t, folder.Properties, "compression", "jpeg is compressed already")
+
+		service.Compression = "lz4"
+		_, err = service.Upload(service.ctx, "/src/log.txt", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("dense codec", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+		service.Codec = "base16384"
+
+		data := randomBytes(uds.Base16384.ChunkSize() + 3)
+		assert.NoError(t, afs.WriteFile("/src/file.bin", data, 0600))
+		media, err := service.Upload(service.ctx, "/src/file.bin", "")
+		assert.NoError(t, err)
+
+		folder, err := backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "base16384", folder.Properties["codec"])
+
+		r, err := backend.List(service.ctx, "'"+media.ID+"' in parents", "")
+		assert.NoError(t, err)
+		assert.Len(t, r.Files, 2, "a base64 upload would take 3 chunks")
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/src/copy.bin"))
+		got, err := afs.ReadFile("/src/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		reader, err := service.NewReader(service.ctx, media.ID)
+		assert.NoError(t, err)
+		p := make([]byte, 6)
+		_, err = reader.ReadAt(p, uds.Base16384.ChunkSize()-3)
+		assert.NoError(t, err)
+		assert.Equal(t, data[uds.Base16384.ChunkSize()-3:], p)
+
+		service.Codec = "base65536"
+		_, err = service.Upload(service.ctx, "/src/file.bin", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("md5 mismatch", func(t *testing.T) {
+		service, media := setup(t, randomBytes(10))
+
+		_, err := service.backend.UpdateProperties(service.ctx, media.ID, map[string]string{"md5": "tampered"})
+		assert.NoError(t, err)
+
+		err = service.Download(service.ctx, media.ID, "/src/copy.bin")
+		assert.Error(t, err)
+
+		exists, err := afero.Exists(AppFs, "/src/copy.bin")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		infos, err := afero.ReadDir(AppFs, "/src")
+		assert.NoError(t, err)
+		assert.Len(t, infos, 1, "temporary file should be removed")
+	})
+
+	t.Run("missing chunk", func(t *testing.T) {
+		service, media := setup(t, randomBytes(uds.ChunkReadLengthBytes+1))
+
+		docs, err := service.listChunks(service.ctx, media.ID, 2)
+		assert.NoError(t, err)
+		assert.NoError(t, service.backend.Delete(service.ctx, docs[1].Id))
+
+		err = service.Download(service.ctx, media.ID, "/src/copy.bin")
+		assert.EqualError(t, err, "missing chunk part 1 in "+media.ID)
+	})
+
+	t.Run("copies of a part", func(t *testing.T) {
+		data := randomBytes(uds.ChunkReadLengthBytes + 1)
+		service, media := setup(t, data)
+		ctx := service.ctx
+
+		docs, err := service.listChunks(ctx, media.ID, 2)
+		assert.NoError(t, err)
+		content, err := service.backend.Export(ctx, docs[0].Id)
+		assert.NoError(t, err)
+		copyOf := func(content string, properties map[string]string) {
+			_, err := service.backend.CreateDoc(ctx, &drive.File{
+				Name:       docs[0].Name,
+				MimeType:   docMimeType,
+				Parents:    docs[0].Parents,
+				Properties: properties,
+			}, content)
+			assert.NoError(t, err)
+		}
+
+		copyOf(content, docs[0].Properties)
+		assert.NoError(t, service.Download(ctx, media.ID, "/src/copy.bin"), "identical copies are ignored")
+		got, err := afero.ReadFile(AppFs, "/src/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		properties := map[string]string{uds.PartProperty: "0", uds.SHA256Property: uds.Checksum(0, "other")}
+		copyOf("other", properties)
+		err = service.Download(ctx, media.ID, "/src/other.bin")
+		assert.EqualError(t, err, "duplicated chunk part 0 in "+media.ID)
+	})
+
+	t.Run("unknown file", func(t *testing.T) {
+		service, _ := setup(t, nil)
+
+		assert.Error(t, service.Download(service.ctx, "unknown", "/src/copy.bin"))
+	})
+}
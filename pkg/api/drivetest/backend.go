@@ -0,0 +1,322 @@
+// Package drivetest provides in-memory stand-ins of Google Drive for tests.
+package drivetest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	folderMimeType = "application/vnd.google-apps.folder"
+	defaultPage    = 1000
+	contentDir     = "/drivetest"
+)
+
+// Backend keeps file metadata in memory and Doc contents in an afero.Fs. It
+// follows the query semantics of Drive so that it can replace it in tests.
+type Backend struct {
+	// PageSize limits the number of files returned by a single List call
+	PageSize int
+
+	fs     afero.Fs
+	mu     sync.Mutex
+	files  map[string]*drive.File
+	order  []string
+	nextID int
+}
+
+// NewBackend function returns an empty Backend storing contents in fs
+func NewBackend(fs afero.Fs) *Backend {
+	_ = fs.MkdirAll(contentDir, 0700)
+	return &Backend{
+		PageSize: defaultPage,
+		fs:       fs,
+		files:    make(map[string]*drive.File),
+	}
+}
+
+// CreateFolder stores the folder metadata
+func (b *Backend) CreateFolder(_ context.Context, file *drive.File) (*drive.File, error) {
+	f := copyFile(file)
+	if f.MimeType == "" {
+		f.MimeType = folderMimeType
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkID(f.Id); err != nil {
+		return nil, err
+	}
+	return copyFile(b.add(f)), nil
+}
+
+// CreateDoc stores the file metadata along with its content
+func (b *Backend) CreateDoc(_ context.Context, file *drive.File, content string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkID(file.Id); err != nil {
+		return nil, err
+	}
+
+	f := b.add(copyFile(file))
+	if err := afero.WriteFile(b.fs, b.contentPath(f.Id), []byte(content), 0600); err != nil {
+		b.remove(f.Id)
+		return nil, err
+	}
+	return copyFile(f), nil
+}
+
+// List returns the files matching the Drive search query q in creation order
+func (b *Backend) List(_ context.Context, q, pageToken string) (*drive.FileList, error) {
+	return b.list(q, pageToken, b.PageSize)
+}
+
+func (b *Backend) list(q, pageToken string, pageSize int) (*drive.FileList, error) {
+	match, err := ParseQuery(q)
+	if err != nil {
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	start := 0
+	if pageToken != "" {
+		if start, err = strconv.Atoi(pageToken); err != nil {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "invalid page token"}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := &drive.FileList{Files: []*drive.File{}}
+	for i := start; i < len(b.order); i++ {
+		f := b.files[b.order[i]]
+		if !match(f) {
+			continue
+		}
+		if len(r.Files) == pageSize {
+			r.NextPageToken = strconv.Itoa(i)
+			break
+		}
+		r.Files = append(r.Files, copyFile(f))
+	}
+	return r, nil
+}
+
+// Get returns the metadata of the file id
+func (b *Backend) Get(_ context.Context, id string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.files[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return copyFile(f), nil
+}
+
+// Export returns the content of the Doc id
+func (b *Backend) Export(_ context.Context, id string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.files[id]; !ok {
+		return "", notFound(id)
+	}
+	content, err := afero.ReadFile(b.fs, b.contentPath(id))
+	if err != nil {
+		return "", notFound(id)
+	}
+	return string(content), nil
+}
+
+// Delete removes the file id, and every descendant when it is a folder
+func (b *Backend) Delete(_ context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.files[id]; !ok {
+		return notFound(id)
+	}
+	b.remove(id)
+	return nil
+}
+
+// UpdateProperties merges properties into the properties of the file id
+func (b *Backend) UpdateProperties(_ context.Context, id string, properties map[string]string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.files[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	if f.Properties == nil {
+		f.Properties = make(map[string]string)
+	}
+	for k, v := range properties {
+		f.Properties[k] = v
+	}
+	f.ModifiedTime = now()
+	return copyFile(f), nil
+}
+
+// SetTrashed moves the file id to or out of the trash
+func (b *Backend) SetTrashed(_ context.Context, id string, trashed bool) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.files[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	f.Trashed = trashed
+	f.ModifiedTime = now()
+	return copyFile(f), nil
+}
+
+// Move takes the file id out of the folders from and puts it in to
+func (b *Backend) Move(_ context.Context, id string, from []string, to string) (*drive.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.files[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	if _, ok := b.files[to]; !ok {
+		return nil, notFound(to)
+	}
+
+	parents := []string{to}
+	for _, parent := range f.Parents {
+		removed := parent == to
+		for _, old := range from {
+			removed = removed || parent == old
+		}
+		if !removed {
+			parents = append(parents, parent)
+		}
+	}
+	f.Parents = parents
+	f.ModifiedTime = now()
+	return copyFile(f), nil
+}
+
+// About returns a fixed user along with the storage used by Doc contents
+func (b *Backend) About(_ context.Context) (*drive.About, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var usage int64
+	for id := range b.files {
+		if info, err := b.fs.Stat(b.contentPath(id)); err == nil {
+			usage += info.Size()
+		}
+	}
+	return &drive.About{
+		User: &drive.User{
+			DisplayName:  "Drive Test",
+			EmailAddress: "drivetest@example.com",
+		},
+		StorageQuota: &drive.AboutStorageQuota{Usage: usage},
+	}, nil
+}
+
+// GenerateIDs returns ids that files may be created with
+func (b *Backend) GenerateIDs(_ context.Context, count int) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ids := make([]string, count)
+	for i := range ids {
+		b.nextID++
+		ids[i] = fmt.Sprintf("file-%d", b.nextID)
+	}
+	return ids, nil
+}
+
+// checkID fails with 409 like Drive when a file was created with id already
+func (b *Backend) checkID(id string) error {
+	if _, ok := b.files[id]; ok && id != "" {
+		return &googleapi.Error{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("A file already exists with the provided ID: %s.", id),
+		}
+	}
+	return nil
+}
+
+func (b *Backend) add(f *drive.File) *drive.File {
+	if f.Id == "" {
+		b.nextID++
+		f.Id = fmt.Sprintf("file-%d", b.nextID)
+	}
+	f.CreatedTime = now()
+	f.ModifiedTime = f.CreatedTime
+
+	b.files[f.Id] = f
+	b.order = append(b.order, f.Id)
+	return f
+}
+
+func (b *Backend) remove(id string) {
+	var children []string
+	for _, childID := range b.order {
+		for _, parent := range b.files[childID].Parents {
+			if parent == id {
+				children = append(children, childID)
+				break
+			}
+		}
+	}
+	for _, childID := range children {
+		b.remove(childID)
+	}
+
+	delete(b.files, id)
+	_ = b.fs.Remove(b.contentPath(id))
+	for i, fileID := range b.order {
+		if fileID == id {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *Backend) contentPath(id string) string {
+	return path.Join(contentDir, id)
+}
+
+func copyFile(f *drive.File) *drive.File {
+	c := *f
+	if f.Parents != nil {
+		c.Parents = append([]string{}, f.Parents...)
+	}
+	if f.Properties != nil {
+		c.Properties = make(map[string]string, len(f.Properties))
+		for k, v := range f.Properties {
+			c.Properties[k] = v
+		}
+	}
+	return &c
+}
+
+func notFound(id string) error {
+	return &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("File not found: %s.", id),
+	}
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,202 @@
+package drivetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func() *Backend {
+		return NewBackend(afero.NewMemMapFs())
+	}
+
+	assertNotFound := func(t *testing.T, err error) {
+		e, ok := err.(*googleapi.Error)
+		if assert.True(t, ok, err) {
+			assert.Equal(t, http.StatusNotFound, e.Code)
+		}
+	}
+
+	t.Run("create and get", func(t *testing.T) {
+		b := setup()
+
+		props := map[string]string{"uds": "true"}
+		folder, err := b.CreateFolder(ctx, &drive.File{Name: "folder", Properties: props})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, folder.Id)
+		assert.Equal(t, folderMimeType, folder.MimeType)
+		assert.NotEmpty(t, folder.ModifiedTime)
+
+		props["uds"] = "changed"
+		got, err := b.Get(ctx, folder.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "true", got.Properties["uds"], "stored metadata should not alias the argument")
+
+		doc, err := b.CreateDoc(ctx, &drive.File{Name: "doc", Parents: []string{folder.Id}}, "content")
+		assert.NoError(t, err)
+
+		content, err := b.Export(ctx, doc.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "content", content)
+
+		_, err = b.Export(ctx, folder.Id)
+		assertNotFound(t, err)
+
+		_, err = b.Get(ctx, "unknown")
+		assertNotFound(t, err)
+	})
+
+	t.Run("list with pages", func(t *testing.T) {
+		b := setup()
+		b.PageSize = 2
+
+		folder, err := b.CreateFolder(ctx, &drive.File{Name: "folder"})
+		assert.NoError(t, err)
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			_, err := b.CreateDoc(ctx, &drive.File{Name: name, Parents: []string{folder.Id}}, name)
+			assert.NoError(t, err)
+		}
+
+		var names []string
+		pageToken := ""
+		for {
+			r, err := b.List(ctx, "'"+folder.Id+"' in parents", pageToken)
+			assert.NoError(t, err)
+			assert.True(t, len(r.Files) <= 2)
+			for _, f := range r.Files {
+				names = append(names, f.Name)
+			}
+			if r.NextPageToken == "" {
+				break
+			}
+			pageToken = r.NextPageToken
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+
+		_, err = b.List(ctx, "invalid query", "")
+		assert.Error(t, err)
+
+		_, err = b.List(ctx, "", "invalid token")
+		assert.Error(t, err)
+	})
+
+	t.Run("update properties", func(t *testing.T) {
+		b := setup()
+
+		folder, err := b.CreateFolder(ctx, &drive.File{Name: "folder"})
+		assert.NoError(t, err)
+
+		got, err := b.UpdateProperties(ctx, folder.Id, map[string]string{"size": "10"})
+		assert.NoError(t, err)
+		assert.Equal(t, "10", got.Properties["size"])
+
+		got, err = b.UpdateProperties(ctx, folder.Id, map[string]string{"md5": "1234"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"size": "10", "md5": "1234"}, got.Properties)
+
+		_, err = b.UpdateProperties(ctx, "unknown", nil)
+		assertNotFound(t, err)
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		b := setup()
+
+		folder, err := b.CreateFolder(ctx, &drive.File{Name: "folder"})
+		assert.NoError(t, err)
+
+		got, err := b.SetTrashed(ctx, folder.Id, true)
+		assert.NoError(t, err)
+		assert.True(t, got.Trashed)
+
+		r, err := b.List(ctx, "trashed=true", "")
+		assert.NoError(t, err)
+		assert.Len(t, r.Files, 1)
+
+		got, err = b.SetTrashed(ctx, folder.Id, false)
+		assert.NoError(t, err)
+		assert.False(t, got.Trashed)
+
+		_, err = b.SetTrashed(ctx, "unknown", true)
+		assertNotFound(t, err)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		b := setup()
+
+		from, err := b.CreateFolder(ctx, &drive.File{Name: "from"})
+		assert.NoError(t, err)
+		to, err := b.CreateFolder(ctx, &drive.File{Name: "to"})
+		assert.NoError(t, err)
+		doc, err := b.CreateDoc(ctx, &drive.File{Name: "doc", Parents: []string{from.Id, "other"}}, "content")
+		assert.NoError(t, err)
+
+		got, err := b.Move(ctx, doc.Id, []string{from.Id}, to.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{to.Id, "other"}, got.Parents)
+
+		r, err := b.List(ctx, "'"+from.Id+"' in parents", "")
+		assert.NoError(t, err)
+		assert.Empty(t, r.Files)
+
+		_, err = b.Move(ctx, "unknown", nil, to.Id)
+		assertNotFound(t, err)
+		_, err = b.Move(ctx, doc.Id, nil, "unknown")
+		assertNotFound(t, err)
+	})
+
+	t.Run("generated ids", func(t *testing.T) {
+		b := setup()
+
+		ids, err := b.GenerateIDs(ctx, 2)
+		assert.NoError(t, err)
+		assert.Len(t, ids, 2)
+		assert.NotEqual(t, ids[0], ids[1])
+
+		doc, err := b.CreateDoc(ctx, &drive.File{Id: ids[0], Name: "doc"}, "content")
+		assert.NoError(t, err)
+		assert.Equal(t, ids[0], doc.Id)
+		other, err := b.CreateDoc(ctx, &drive.File{Name: "other"}, "content")
+		assert.NoError(t, err)
+		assert.NotContains(t, ids, other.Id, "generated ids are not given to other files")
+
+		_, err = b.CreateDoc(ctx, &drive.File{Id: ids[0], Name: "again"}, "content")
+		e, ok := err.(*googleapi.Error)
+		if assert.True(t, ok, err) {
+			assert.Equal(t, http.StatusConflict, e.Code)
+		}
+		_, err = b.CreateFolder(ctx, &drive.File{Id: ids[0], Name: "again"})
+		assert.Error(t, err)
+	})
+
+	t.Run("delete folder with descendants", func(t *testing.T) {
+		b := setup()
+
+		folder, err := b.CreateFolder(ctx, &drive.File{Name: "folder"})
+		assert.NoError(t, err)
+		sub, err := b.CreateFolder(ctx, &drive.File{Name: "sub", Parents: []string{folder.Id}})
+		assert.NoError(t, err)
+		doc, err := b.CreateDoc(ctx, &drive.File{Name: "doc", Parents: []string{sub.Id}}, "content")
+		assert.NoError(t, err)
+		other, err := b.CreateFolder(ctx, &drive.File{Name: "other"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, b.Delete(ctx, folder.Id))
+
+		for _, id := range []string{folder.Id, sub.Id, doc.Id} {
+			_, err := b.Get(ctx, id)
+			assertNotFound(t, err)
+		}
+		_, err = b.Get(ctx, other.Id)
+		assert.NoError(t, err)
+
+		assertNotFound(t, b.Delete(ctx, folder.Id))
+	})
+}
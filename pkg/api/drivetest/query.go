@@ -0,0 +1,356 @@
+package drivetest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Predicate reports whether a file matches a search query
+type Predicate func(f *drive.File) bool
+
+// ParseQuery compiles the subset of the Drive v3 search syntax used by UDS.
+// Supported terms are `properties has {key='k' and value='v'}`, `'id' in
+// parents`, comparisons on name, mimeType, modifiedTime and trashed, and any
+// combination of them with and, or, not and parentheses. An empty query
+// matches every file.
+func ParseQuery(q string) (Predicate, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return func(*drive.File) bool { return true }, nil
+	}
+
+	p := &parser{tokens: tokens}
+	pred, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query", p.tokens[p.pos].text)
+	}
+	return pred, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(q string) ([]token, error) {
+	var tokens []token
+	rs := []rune(q)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			var sb strings.Builder
+			i++
+			for ; i < len(rs) && rs[i] != '\''; i++ {
+				if rs[i] == '\\' {
+					i++
+					if i == len(rs) {
+						break
+					}
+				}
+				sb.WriteRune(rs[i])
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("unterminated string in query %q", q)
+			}
+			i++
+			tokens = append(tokens, token{tokenString, sb.String()})
+		case strings.ContainsRune("{}()", r):
+			tokens = append(tokens, token{tokenSymbol, string(r)})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			op := string(r)
+			if i+1 < len(rs) && rs[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' in query %q", q)
+			}
+			tokens = append(tokens, token{tokenSymbol, op})
+			i += len(op)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, string(rs[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in query %q", r, q)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, error) {
+	t, ok := p.peek()
+	if !ok {
+		return token{}, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	t, ok := p.peek()
+	if ok && t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.accept(kind, text) {
+		t, _ := p.peek()
+		return fmt.Errorf("expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) str() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != tokenString {
+		return "", fmt.Errorf("expected string, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) or() (Predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenWord, "or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *drive.File) bool { return l(f) || right(f) }
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Predicate, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenWord, "and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *drive.File) bool { return l(f) && right(f) }
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Predicate, error) {
+	if p.accept(tokenWord, "not") {
+		pred, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *drive.File) bool { return !pred(f) }, nil
+	}
+	if p.accept(tokenSymbol, "(") {
+		pred, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenSymbol, ")"); err != nil {
+			return nil, err
+		}
+		return pred, nil
+	}
+	return p.term()
+}
+
+func (p *parser) term() (Predicate, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.kind == tokenString {
+		if err := p.expect(tokenWord, "in"); err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenWord, "parents"); err != nil {
+			return nil, err
+		}
+		parent := t.text
+		return func(f *drive.File) bool {
+			for _, id := range f.Parents {
+				if id == parent {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("unexpected %q in query", t.text)
+	}
+
+	switch t.text {
+	case "properties":
+		return p.properties()
+	case "trashed":
+		return p.boolean(func(f *drive.File) bool { return f.Trashed })
+	case "name":
+		return p.text(func(f *drive.File) string { return f.Name })
+	case "mimeType":
+		return p.text(func(f *drive.File) string { return f.MimeType })
+	case "modifiedTime":
+		return p.time(func(f *drive.File) string { return f.ModifiedTime })
+	}
+	return nil, fmt.Errorf("unsupported query field %q", t.text)
+}
+
+func (p *parser) properties() (Predicate, error) {
+	var key, value string
+	for _, step := range []func() error{
+		func() error { return p.expect(tokenWord, "has") },
+		func() error { return p.expect(tokenSymbol, "{") },
+		func() error { return p.expect(tokenWord, "key") },
+		func() error { return p.expect(tokenSymbol, "=") },
+		func() (err error) { key, err = p.str(); return },
+		func() error { return p.expect(tokenWord, "and") },
+		func() error { return p.expect(tokenWord, "value") },
+		func() error { return p.expect(tokenSymbol, "=") },
+		func() (err error) { value, err = p.str(); return },
+		func() error { return p.expect(tokenSymbol, "}") },
+	} {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	return func(f *drive.File) bool {
+		v, ok := f.Properties[key]
+		return ok && v == value
+	}, nil
+}
+
+func (p *parser) operator(allowed ...string) (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	for _, op := range allowed {
+		if t.text == op {
+			return op, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported operator %q", t.text)
+}
+
+func (p *parser) boolean(field func(*drive.File) bool) (Predicate, error) {
+	op, err := p.operator("=", "!=")
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenWord || (t.text != "true" && t.text != "false") {
+		return nil, fmt.Errorf("expected boolean, got %q", t.text)
+	}
+	want := (t.text == "true") == (op == "=")
+	return func(f *drive.File) bool { return field(f) == want }, nil
+}
+
+func (p *parser) text(field func(*drive.File) string) (Predicate, error) {
+	op, err := p.operator("=", "!=", "contains")
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "=":
+		return func(f *drive.File) bool { return field(f) == value }, nil
+	case "!=":
+		return func(f *drive.File) bool { return field(f) != value }, nil
+	}
+	value = strings.ToLower(value)
+	return func(f *drive.File) bool {
+		return strings.Contains(strings.ToLower(field(f)), value)
+	}, nil
+}
+
+func (p *parser) time(field func(*drive.File) string) (Predicate, error) {
+	op, err := p.operator("=", "!=", "<", "<=", ">", ">=")
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	want, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return func(f *drive.File) bool {
+		got, err := time.Parse(time.RFC3339, field(f))
+		if err != nil {
+			return false
+		}
+		switch op {
+		case "=":
+			return got.Equal(want)
+		case "!=":
+			return !got.Equal(want)
+		case "<":
+			return got.Before(want)
+		case "<=":
+			return !got.After(want)
+		case ">":
+			return got.After(want)
+		}
+		return !got.Before(want)
+	}, nil
+}
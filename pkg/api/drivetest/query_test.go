@@ -0,0 +1,78 @@
+package drivetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestParseQuery(t *testing.T) {
+	file := &drive.File{
+		Name:         "it's a file.txt",
+		MimeType:     "text/plain",
+		Parents:      []string{"parent-1"},
+		Properties:   map[string]string{"uds": "true", "part": "3"},
+		ModifiedTime: "2020-01-02T03:04:05.678Z",
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"properties has {key='uds' and value='true'}", true},
+		{"properties has {key='uds' and value='false'}", false},
+		{"properties has {key='udsRoot' and value='true'}", false},
+		{"'parent-1' in parents", true},
+		{"'parent-2' in parents", false},
+		{"trashed=false", true},
+		{"trashed = true", false},
+		{"trashed != true", true},
+		{`name contains 'it\'s'`, true},
+		{"name contains 'FILE'", true},
+		{"name contains 'dir'", false},
+		{`name = 'it\'s a file.txt'`, true},
+		{"name != 'other'", true},
+		{"mimeType = 'text/plain'", true},
+		{"mimeType != 'text/plain'", false},
+		{"modifiedTime > '2020-01-01T00:00:00Z'", true},
+		{"modifiedTime < '2020-01-01T00:00:00Z'", false},
+		{"modifiedTime >= '2020-01-02T03:04:05.678Z'", true},
+		{"modifiedTime <= '2020-01-02T03:04:05.678Z'", true},
+		{"modifiedTime = '2020-01-02T03:04:05.678Z'", true},
+		{"modifiedTime != '2020-01-02T03:04:05.678Z'", false},
+		{"trashed=false and 'parent-1' in parents", true},
+		{"trashed=true and 'parent-1' in parents", false},
+		{"trashed=true or 'parent-1' in parents", true},
+		{"not trashed=true", true},
+		{"not (trashed=false and name contains 'zzz')", true},
+		{"(trashed=true or name contains 'file') and mimeType = 'text/plain'", true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			match, err := ParseQuery(tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, match(file))
+		})
+	}
+
+	for _, query := range []string{
+		"name contains 'unterminated",
+		"trashed = maybe",
+		"size > '3'",
+		"name > 'a'",
+		"properties has {key='uds'}",
+		"(trashed=false",
+		"trashed=false and",
+		"trashed=false trashed=true",
+		"modifiedTime > 'yesterday'",
+		"name ! 'a'",
+		"'parent-1' in",
+		"#",
+	} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			_, err := ParseQuery(query)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package drivetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Server is a local stand-in of the Drive v3 REST API serving a Backend.
+// Point drive.NewService at it with option.WithEndpoint(srv.URL + "/") and
+// option.WithHTTPClient(srv.Client()).
+type Server struct {
+	*httptest.Server
+	Backend *Backend
+}
+
+// NewServer function starts a Server storing contents in fs
+func NewServer(fs afero.Fs) *Server {
+	s := &Server{Backend: NewBackend(fs)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	path = strings.TrimPrefix(path, "upload/")
+	segments := strings.Split(path, "/")
+
+	var (
+		v   interface{}
+		err error
+	)
+	switch {
+	case path == "about" && r.Method == http.MethodGet:
+		v, err = s.Backend.About(r.Context())
+	case path == "files" && r.Method == http.MethodGet:
+		v, err = s.list(r)
+	case path == "files" && r.Method == http.MethodPost:
+		v, err = s.create(r)
+	case path == "files/generateIds" && r.Method == http.MethodGet:
+		v, err = s.generateIDs(r)
+	case len(segments) == 2 && segments[0] == "files" && r.Method == http.MethodGet:
+		if r.URL.Query().Get("alt") == "media" {
+			s.content(w, r, segments[1])
+			return
+		}
+		v, err = s.Backend.Get(r.Context(), segments[1])
+	case len(segments) == 2 && segments[0] == "files" && r.Method == http.MethodPatch:
+		v, err = s.update(r, segments[1])
+	case len(segments) == 2 && segments[0] == "files" && r.Method == http.MethodDelete:
+		if err = s.Backend.Delete(r.Context(), segments[1]); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case len(segments) == 3 && segments[0] == "files" && segments[2] == "export" && r.Method == http.MethodGet:
+		s.content(w, r, segments[1])
+		return
+	default:
+		err = &googleapi.Error{Code: http.StatusNotFound, Message: "unknown endpoint " + r.Method + " " + r.URL.Path}
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) list(r *http.Request) (*drive.FileList, error) {
+	query := r.URL.Query()
+	pageSize := s.Backend.PageSize
+	if v := query.Get("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "invalid pageSize"}
+		}
+		if n < pageSize {
+			pageSize = n
+		}
+	}
+	return s.Backend.list(query.Get("q"), query.Get("pageToken"), pageSize)
+}
+
+func (s *Server) generateIDs(r *http.Request) (*drive.GeneratedIds, error) {
+	count := 10
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "invalid count"}
+		}
+		count = n
+	}
+	ids, err := s.Backend.GenerateIDs(r.Context(), count)
+	if err != nil {
+		return nil, err
+	}
+	return &drive.GeneratedIds{Ids: ids, Space: "drive"}, nil
+}
+
+func (s *Server) create(r *http.Request) (*drive.File, error) {
+	switch uploadType := r.URL.Query().Get("uploadType"); uploadType {
+	case "":
+		var f drive.File
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return s.Backend.CreateFolder(r.Context(), &f)
+	case "multipart":
+		f, content, err := readMultipart(r)
+		if err != nil {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return s.Backend.CreateDoc(r.Context(), f, content)
+	default:
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: "unsupported uploadType " + uploadType}
+	}
+}
+
+func (s *Server) update(r *http.Request, id string) (*drive.File, error) {
+	var body struct {
+		Properties map[string]string `json:"properties"`
+		Trashed    *bool             `json:"trashed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	query := r.URL.Query()
+	if to := query.Get("addParents"); to != "" {
+		var from []string
+		if v := query.Get("removeParents"); v != "" {
+			from = strings.Split(v, ",")
+		}
+		if _, err := s.Backend.Move(r.Context(), id, from, to); err != nil {
+			return nil, err
+		}
+	}
+	if body.Trashed != nil {
+		if _, err := s.Backend.SetTrashed(r.Context(), id, *body.Trashed); err != nil {
+			return nil, err
+		}
+	}
+	return s.Backend.UpdateProperties(r.Context(), id, body.Properties)
+}
+
+func (s *Server) content(w http.ResponseWriter, r *http.Request, id string) {
+	content, err := s.Backend.Export(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// Docs exported as text start with a byte order mark
+	_, _ = io.WriteString(w, "\ufeff"+content+"\r\n")
+}
+
+func readMultipart(r *http.Request) (*drive.File, string, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, "", fmt.Errorf("unexpected content type %s", mediaType)
+	}
+
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, "", err
+	}
+	var f drive.File
+	if err := json.NewDecoder(part).Decode(&f); err != nil {
+		return nil, "", err
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, "", err
+	}
+	return &f, string(content), nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, message := http.StatusInternalServerError, err.Error()
+	if e, ok := err.(*googleapi.Error); ok {
+		code, message = e.Code, e.Message
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
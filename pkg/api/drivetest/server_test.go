@@ -0,0 +1,125 @@
+package drivetest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Server, *drive.Service) {
+		srv := NewServer(afero.NewMemMapFs())
+		t.Cleanup(srv.Close)
+
+		service, err := drive.NewService(ctx,
+			option.WithEndpoint(srv.URL+"/"),
+			option.WithHTTPClient(srv.Client()),
+		)
+		assert.NoError(t, err)
+		return srv, service
+	}
+
+	assertCode := func(t *testing.T, code int, err error) {
+		e, ok := err.(*googleapi.Error)
+		if assert.True(t, ok, err) {
+			assert.Equal(t, code, e.Code)
+		}
+	}
+
+	t.Run("create, list and read", func(t *testing.T) {
+		_, service := setup(t)
+
+		folder, err := service.Files.Create(&drive.File{
+			Name:       "it's a folder",
+			MimeType:   folderMimeType,
+			Properties: map[string]string{"uds": "true"},
+		}).Do()
+		assert.NoError(t, err)
+		assert.NotEmpty(t, folder.Id)
+
+		doc, err := service.Files.Create(&drive.File{
+			Name:       "doc0",
+			MimeType:   "application/vnd.google-apps.document",
+			Parents:    []string{folder.Id},
+			Properties: map[string]string{"part": "0"},
+		}).Media(strings.NewReader("content"), googleapi.ContentType("text/plain")).Do()
+		assert.NoError(t, err)
+
+		r, err := service.Files.List().Q(`name contains 'it\'s' and properties has {key='uds' and value='true'} and trashed=false`).Do()
+		assert.NoError(t, err)
+		if assert.Len(t, r.Files, 1) {
+			assert.Equal(t, folder.Id, r.Files[0].Id)
+			assert.Equal(t, "true", r.Files[0].Properties["uds"])
+		}
+
+		got, err := service.Files.Get(doc.Id).Do()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{folder.Id}, got.Parents)
+
+		for _, download := range []func(...googleapi.CallOption) (*http.Response, error){
+			service.Files.Get(doc.Id).Download,
+			service.Files.Export(doc.Id, "text/plain").Download,
+		} {
+			resp, err := download()
+			if assert.NoError(t, err) {
+				b, err := ioutil.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.NoError(t, resp.Body.Close())
+				assert.Equal(t, "\ufeffcontent\r\n", string(b))
+			}
+		}
+
+		updated, err := service.Files.Update(folder.Id, &drive.File{Properties: map[string]string{"md5": "1234"}}).Do()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"uds": "true", "md5": "1234"}, updated.Properties)
+
+		assert.NoError(t, service.Files.Delete(folder.Id).Do())
+
+		_, err = service.Files.Get(doc.Id).Do()
+		assertCode(t, http.StatusNotFound, err)
+		_, err = service.Files.Export(doc.Id, "text/plain").Download()
+		assertCode(t, http.StatusNotFound, err)
+	})
+
+	t.Run("list pages", func(t *testing.T) {
+		srv, service := setup(t)
+
+		for i := 0; i < 5; i++ {
+			_, err := srv.Backend.CreateFolder(ctx, &drive.File{Name: "folder"})
+			assert.NoError(t, err)
+		}
+
+		pages := 0
+		var files []*drive.File
+		err := service.Files.List().Q("name = 'folder'").PageSize(2).Pages(ctx, func(r *drive.FileList) error {
+			pages++
+			files = append(files, r.Files...)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, pages)
+		assert.Len(t, files, 5)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		_, service := setup(t)
+
+		_, err := service.Files.List().Q("size > 3").Do()
+		assertCode(t, http.StatusBadRequest, err)
+
+		_, err = service.Files.Copy("unknown", &drive.File{}).Do()
+		assertCode(t, http.StatusNotFound, err)
+
+		assertCode(t, http.StatusNotFound, service.Files.Delete("unknown").Do())
+	})
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// GetFile returns the UDS file id
+func (api *Service) GetFile(ctx context.Context, id string) (*uds.File, error) {
+	folder, meta, err := api.getMedia(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	file := meta.File(folder.Name, folder.Id, folder.Parents)
+	file.Shared = folder.Shared
+	file.Trashed = folder.Trashed
+	return file, nil
+}
+
+// FindFiles returns every UDS file named exactly name
+func (api *Service) FindFiles(ctx context.Context, name string) ([]*uds.File, error) {
+	var files []*uds.File
+	opts := ListOptions{Filter: NewQuery().NameEquals(name)}
+	err := api.ListFilesIter(ctx, opts, func(file *uds.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// About returns the signed in user and the storage quota of the account
+func (api *Service) About(ctx context.Context) (*drive.About, error) {
+	return api.backend.About(ctx)
+}
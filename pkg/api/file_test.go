@@ -0,0 +1,57 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestFile(t *testing.T) {
+	setup := func(t *testing.T) (*Service, *uds.File) {
+		service, _, afs := setupBackend(t)
+
+		assert.NoError(t, afs.WriteFile("/notes.txt", []byte("hello"), 0600))
+		media, err := service.Upload(service.ctx, "/notes.txt", "")
+		assert.NoError(t, err)
+		return service, media
+	}
+
+	t.Run("get", func(t *testing.T) {
+		service, media := setup(t)
+
+		got, err := service.GetFile(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, media, got)
+
+		root, err := service.GetBaseFolder()
+		assert.NoError(t, err)
+		_, err = service.GetFile(service.ctx, root.Id)
+		assert.Error(t, err, "root is not a media folder")
+
+		_, err = service.GetFile(service.ctx, "unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("find by name", func(t *testing.T) {
+		service, media := setup(t)
+
+		files, err := service.FindFiles(service.ctx, "notes.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, []*uds.File{media}, files)
+
+		files, err = service.FindFiles(service.ctx, "notes")
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("about", func(t *testing.T) {
+		service, _ := setup(t)
+
+		about, err := service.About(service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "drivetest@example.com", about.User.EmailAddress)
+		assert.Equal(t, int64(len("aGVsbG8=")), about.StorageQuota.Usage)
+	})
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+const (
+	quarantineFolderName = "UDS Quarantine"
+	// quarantinedMedia replaces the media tag of quarantined media folders,
+	// so that they are no longer listed nor downloaded
+	quarantinedMedia = "quarantined"
+
+	// DefaultRepairMinAge is how long an incomplete file is left alone after
+	// its last change when RepairOptions.MinAge is not set
+	DefaultRepairMinAge = 24 * time.Hour
+)
+
+// Kinds of problems found by Scan
+const (
+	// ProblemOrphan is a chunk Doc outside of any media folder. Only Docs
+	// tagged as chunks count, as any app may set a part property on its own.
+	ProblemOrphan = "orphan"
+	// ProblemIncomplete is a media folder missing chunks, or holding chunks
+	// past its recorded size as left by a writer that was never closed
+	ProblemIncomplete = "incomplete"
+	// ProblemDuplicate is an extra chunk Doc of a part that has one already
+	ProblemDuplicate = "duplicate"
+)
+
+// Actions taken by Repair
+const (
+	RepairDelete     = "delete"
+	RepairQuarantine = "quarantine"
+	RepairKeep       = "keep"
+)
+
+// Problem struct describes a Drive file found broken by Scan
+type Problem struct {
+	Kind string `json:"kind"`
+	// ID is the media folder of incomplete files, and the chunk Doc otherwise
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Parents []string `json:"parents"`
+	Detail  string   `json:"detail"`
+	// Resumable tells the upload of an incomplete file is journaled and can
+	// be completed with ResumeUpload, so Repair keeps it
+	Resumable bool `json:"resumable"`
+	// Modified is the last change of an incomplete file or of its chunks
+	Modified time.Time `json:"modified,omitempty"`
+	// Recent tells Repair kept an incomplete file changed too recently, as its
+	// upload may still be running elsewhere
+	Recent bool `json:"recent,omitempty"`
+
+	// Action is what Repair did, or would do in a dry run
+	Action string `json:"action,omitempty"`
+	// Done tells Action was carried out
+	Done bool `json:"done"`
+}
+
+// ScanReport struct lists the problems found by Scan
+type ScanReport struct {
+	// Root is the id of the UDS root that was scanned
+	Root string `json:"root"`
+	// Files counts the media folders scanned
+	Files    int        `json:"files"`
+	Problems []*Problem `json:"problems"`
+}
+
+// RepairOptions struct tells Repair what to do with the problems of a report
+type RepairOptions struct {
+	// Action is RepairDelete or RepairQuarantine, which moves the files to a
+	// quarantine folder under the UDS root
+	Action string
+	// DryRun only records in the report what would be done
+	DryRun bool
+	// MinAge keeps incomplete files changed more recently, as they may be
+	// uploads still running on another machine, or writers still open.
+	// DefaultRepairMinAge when 0.
+	MinAge time.Duration
+	// Clock tells the age of the files, the wall clock when nil
+	Clock Clock
+}
+
+// Scan walks the media folders under the UDS root and looks for incomplete
+// files and duplicated chunks, then for chunk Docs anywhere in Drive that
+// are not inside a media folder. Chunk Docs stored before they were tagged
+// with uds.ChunkProperty are not looked for. Nothing is changed; see Repair.
+func (api *Service) Scan(ctx context.Context) (*ScanReport, error) {
+	root, err := api.GetBaseFolder()
+	if err != nil {
+		return nil, err
+	}
+	resumable, err := api.journaledFolders()
+	if err != nil {
+		return nil, err
+	}
+	report := &ScanReport{Root: root.Id}
+
+	// parents tells whether a folder may hold chunk Docs
+	parents := make(map[string]bool)
+	var folders []*drive.File
+	q := NewQuery().Parent(root.Id).Trashed(false)
+	err = api.listAll(ctx, q.String(), func(f *drive.File) error {
+		if f.Properties[uds.QuarantineProperty] == "true" {
+			parents[f.Id] = true
+		} else if _, err := uds.ParseMetadata(f.Properties); err == nil {
+			folders = append(folders, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, folder := range folders {
+		parents[folder.Id] = true
+		problems, err := api.scanMedia(ctx, folder, resumable[folder.Id])
+		if err != nil {
+			return nil, err
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+	report.Files = len(folders)
+
+	var chunks []*drive.File
+	q = NewQuery().MimeType(docMimeType).Property(uds.ChunkProperty, "true").Trashed(false)
+	err = api.listAll(ctx, q.String(), func(f *drive.File) error {
+		if _, err := uds.ParsePart(f.Properties); err == nil {
+			chunks = append(chunks, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		orphan := true
+		for _, parent := range chunk.Parents {
+			ok, err := api.holdsChunks(ctx, parent, parents)
+			if err != nil {
+				return nil, err
+			}
+			orphan = orphan && !ok
+		}
+		if orphan {
+			report.Problems = append(report.Problems, &Problem{
+				Kind:    ProblemOrphan,
+				ID:      chunk.Id,
+				Name:    chunk.Name,
+				Parents: chunk.Parents,
+				Detail:  "chunk Doc outside of any media folder",
+			})
+		}
+	}
+	return report, nil
+}
+
+// scanMedia returns the problems of a media folder
+func (api *Service) scanMedia(ctx context.Context, folder *drive.File, resumable bool) ([]*Problem, error) {
+	meta, err := uds.ParseMetadata(folder.Properties)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := uds.CodecByName(meta.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %v", folder.Name, folder.Id, err)
+	}
+	count := uds.NumChunks(meta.Size, codec.ChunkSize())
+
+	parts := make([][]*drive.File, count)
+	past := 0
+	modified := modifiedTime(folder)
+	q := NewQuery().Parent(folder.Id).Trashed(false)
+	err = api.listAll(ctx, q.String(), func(f *drive.File) error {
+		if t := modifiedTime(f); t.After(modified) {
+			modified = t
+		}
+		part, err := uds.ParsePart(f.Properties)
+		if err != nil {
+			return nil
+		}
+		if part >= count {
+			past++
+			return nil
+		}
+		parts[part] = append(parts[part], f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missing := 0
+	for _, docs := range parts {
+		if len(docs) == 0 {
+			missing++
+		}
+	}
+	if missing > 0 || past > 0 {
+		var details []string
+		if missing > 0 {
+			details = append(details, fmt.Sprintf("%d of %d parts missing", missing, count))
+		}
+		if past > 0 {
+			details = append(details, fmt.Sprintf("%d chunks past its size", past))
+		}
+		return []*Problem{{
+			Kind:      ProblemIncomplete,
+			ID:        folder.Id,
+			Name:      folder.Name,
+			Parents:   folder.Parents,
+			Detail:    strings.Join(details, ", "),
+			Resumable: resumable,
+			Modified:  modified,
+		}}, nil
+	}
+
+	var problems []*Problem
+	for part, docs := range parts {
+		for _, doc := range docs[1:] {
+			problems = append(problems, &Problem{
+				Kind:    ProblemDuplicate,
+				ID:      doc.Id,
+				Name:    doc.Name,
+				Parents: doc.Parents,
+				Detail:  fmt.Sprintf("extra copy of part %d of %s", part, folder.Name),
+			})
+		}
+	}
+	return problems, nil
+}
+
+// modifiedTime returns the modifiedTime of f, zero when unknown
+func modifiedTime(f *drive.File) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, f.ModifiedTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// holdsChunks reports whether the folder id is a media or quarantine folder,
+// trashed or not, caching the answer in known
+func (api *Service) holdsChunks(ctx context.Context, id string, known map[string]bool) (bool, error) {
+	if ok, found := known[id]; found {
+		return ok, nil
+	}
+
+	f, err := api.backend.Get(ctx, id)
+	if e, isAPIErr := err.(*googleapi.Error); isAPIErr && e.Code == http.StatusNotFound {
+		known[id] = false
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = uds.ParseMetadata(f.Properties)
+	known[id] = err == nil || f.Properties[uds.QuarantineProperty] == "true"
+	return known[id], nil
+}
+
+// Repair deletes or quarantines the files of the problems in report, and
+// records in every problem what was done. Incomplete files that can still be
+// resumed, or that changed within opts.MinAge, are kept.
+func (api *Service) Repair(ctx context.Context, report *ScanReport, opts RepairOptions) error {
+	if opts.Action != RepairDelete && opts.Action != RepairQuarantine {
+		return fmt.Errorf("unsupported repair action %q", opts.Action)
+	}
+	minAge := opts.MinAge
+	if minAge == 0 {
+		minAge = DefaultRepairMinAge
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = wallClock{}
+	}
+
+	quarantine := ""
+	for _, p := range report.Problems {
+		p.Recent = p.Kind == ProblemIncomplete && clock.Now().Sub(p.Modified) < minAge
+		if p.Resumable || p.Recent {
+			p.Action = RepairKeep
+			continue
+		}
+		p.Action = opts.Action
+		if opts.DryRun {
+			continue
+		}
+
+		var err error
+		switch {
+		case opts.Action == RepairQuarantine:
+			if quarantine == "" {
+				if quarantine, err = api.quarantineFolder(ctx, report.Root); err != nil {
+					return err
+				}
+			}
+			_, err = api.backend.Move(ctx, p.ID, p.Parents, quarantine)
+			if err == nil && p.Kind == ProblemIncomplete {
+				// ListFilesIter finds media folders by their tag, wherever they are
+				_, err = api.backend.UpdateProperties(ctx, p.ID, map[string]string{
+					uds.MediaProperty:      quarantinedMedia,
+					uds.QuarantineProperty: "true",
+				})
+			}
+		case p.Kind == ProblemIncomplete:
+			err = api.Delete(ctx, p.ID, true)
+		default:
+			err = api.backend.Delete(ctx, p.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("%s (%s): %v", p.Name, p.ID, err)
+		}
+		p.Done = true
+	}
+	return nil
+}
+
+// quarantineFolder returns the id of the quarantine folder under the UDS
+// root, creating it the first time
+func (api *Service) quarantineFolder(ctx context.Context, rootID string) (string, error) {
+	q := NewQuery().Parent(rootID).Property(uds.QuarantineProperty, "true").Trashed(false)
+	r, err := api.backend.List(ctx, q.String(), "")
+	if err != nil {
+		return "", err
+	}
+	if len(r.Files) > 0 {
+		return r.Files[0].Id, nil
+	}
+
+	folder, err := api.backend.CreateFolder(ctx, &drive.File{
+		Name:       quarantineFolderName,
+		MimeType:   folderMimeType,
+		Properties: map[string]string{uds.QuarantineProperty: "true"},
+		Parents:    []string{rootID},
+	})
+	if err != nil {
+		return "", err
+	}
+	return folder.Id, nil
+}
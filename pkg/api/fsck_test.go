@@ -0,0 +1,252 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestScan(t *testing.T) {
+	type fixture struct {
+		service    *Service
+		backend    *drivetest.Backend
+		root       string
+		intact     *uds.File
+		incomplete *uds.File
+		resumable  *uds.File
+		duplicate  string
+		orphans    []string
+		userDoc    string
+	}
+
+	setup := func(t *testing.T) *fixture {
+		service, backend, afs := setupBackend(t)
+		service.StateDir = "/state"
+		ctx := service.ctx
+		fx := &fixture{service: service, backend: backend}
+
+		root, err := service.GetBaseFolder()
+		assert.NoError(t, err)
+		fx.root = root.Id
+
+		upload := func(name string, size int64) (*uds.File, []*drive.File) {
+			assert.NoError(t, afs.WriteFile("/"+name, randomBytes(size), 0600))
+			media, err := service.Upload(ctx, "/"+name, "")
+			assert.NoError(t, err)
+			docs, err := service.listChunks(ctx, media.ID, uds.NumChunks(size, uds.ChunkReadLengthBytes))
+			assert.NoError(t, err)
+			return media, docs
+		}
+
+		fx.intact, _ = upload("intact.bin", uds.ChunkReadLengthBytes+1)
+
+		var docs []*drive.File
+		fx.incomplete, docs = upload("incomplete.bin", 2*uds.ChunkReadLengthBytes)
+		assert.NoError(t, backend.Delete(ctx, docs[1].Id))
+
+		fx.resumable, docs = upload("resumable.bin", 2*uds.ChunkReadLengthBytes)
+		assert.NoError(t, backend.Delete(ctx, docs[0].Id))
+		j, err := service.newJournal("/resumable.bin", fx.resumable)
+		assert.NoError(t, err)
+		assert.NoError(t, j.save())
+
+		_, docs = upload("duplicate.bin", 10)
+		content, err := backend.Export(ctx, docs[0].Id)
+		assert.NoError(t, err)
+		dup, err := backend.CreateDoc(ctx, &drive.File{
+			Name:       docs[0].Name,
+			MimeType:   docMimeType,
+			Parents:    docs[0].Parents,
+			Properties: docs[0].Properties,
+		}, content)
+		assert.NoError(t, err)
+		fx.duplicate = dup.Id
+
+		for _, parents := range [][]string{{root.Id}, {"deleted-folder"}, nil} {
+			orphan, err := backend.CreateDoc(ctx, &drive.File{
+				Name:       "lost0",
+				MimeType:   docMimeType,
+				Parents:    parents,
+				Properties: map[string]string{uds.ChunkProperty: "true", uds.PartProperty: "0"},
+			}, "content")
+			assert.NoError(t, err)
+			fx.orphans = append(fx.orphans, orphan.Id)
+		}
+
+		// Docs of the user are no chunks, even when another app tagged them
+		_, err = backend.CreateDoc(ctx, &drive.File{Name: "notes", MimeType: docMimeType, Parents: []string{root.Id}}, "notes")
+		assert.NoError(t, err)
+		tagged, err := backend.CreateDoc(ctx, &drive.File{
+			Name:       "chapter",
+			MimeType:   docMimeType,
+			Properties: map[string]string{uds.PartProperty: "1"},
+		}, "chapter")
+		assert.NoError(t, err)
+		fx.userDoc = tagged.Id
+		return fx
+	}
+
+	// later tells the time once the files of the fixture are old enough
+	later := func() Clock {
+		return &fakeClock{now: time.Now().Add(DefaultRepairMinAge + time.Hour)}
+	}
+
+	kinds := func(report *ScanReport) map[string]string {
+		got := make(map[string]string)
+		for _, p := range report.Problems {
+			got[p.ID] = p.Kind
+		}
+		return got
+	}
+
+	t.Run("classify", func(t *testing.T) {
+		fx := setup(t)
+
+		report, err := fx.service.Scan(fx.service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, fx.root, report.Root)
+		assert.Equal(t, 4, report.Files)
+		assert.Equal(t, map[string]string{
+			fx.incomplete.ID: ProblemIncomplete,
+			fx.resumable.ID:  ProblemIncomplete,
+			fx.duplicate:     ProblemDuplicate,
+			fx.orphans[0]:    ProblemOrphan,
+			fx.orphans[1]:    ProblemOrphan,
+			fx.orphans[2]:    ProblemOrphan,
+		}, kinds(report))
+
+		for _, p := range report.Problems {
+			assert.Equal(t, p.ID == fx.resumable.ID, p.Resumable, p.Name)
+			if p.ID == fx.incomplete.ID {
+				assert.Equal(t, "1 of 2 parts missing", p.Detail)
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		fx := setup(t)
+		ctx := fx.service.ctx
+
+		report, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, report, RepairOptions{Action: RepairDelete, DryRun: true, Clock: later()}))
+		for _, p := range report.Problems {
+			assert.False(t, p.Done)
+			if p.Resumable {
+				assert.Equal(t, RepairKeep, p.Action)
+			} else {
+				assert.Equal(t, RepairDelete, p.Action)
+			}
+		}
+
+		again, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, kinds(report), kinds(again), "nothing should change")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		fx := setup(t)
+		ctx := fx.service.ctx
+
+		report, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, report, RepairOptions{Action: RepairDelete, Clock: later()}))
+
+		again, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{fx.resumable.ID: ProblemIncomplete}, kinds(again))
+
+		_, err = fx.backend.Get(ctx, fx.incomplete.ID)
+		assert.Error(t, err)
+		for _, id := range append(fx.orphans, fx.duplicate) {
+			_, err = fx.backend.Get(ctx, id)
+			assert.Error(t, err, id)
+		}
+
+		_, err = fx.backend.Get(ctx, fx.userDoc)
+		assert.NoError(t, err, "a Doc of the user with a part property should be kept")
+
+		v, err := fx.service.Verify(ctx, fx.intact.ID)
+		assert.NoError(t, err)
+		assert.True(t, v.OK())
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		fx := setup(t)
+		ctx := fx.service.ctx
+
+		report, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, report, RepairOptions{Action: RepairQuarantine, Clock: later()}))
+
+		again, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{fx.resumable.ID: ProblemIncomplete}, kinds(again))
+		assert.Equal(t, 3, again.Files, "only the incomplete file is moved out")
+
+		q := NewQuery().Parent(fx.root).Property(uds.QuarantineProperty, "true")
+		r, err := fx.backend.List(ctx, q.String(), "")
+		assert.NoError(t, err)
+		assert.Len(t, r.Files, 1)
+		quarantine := r.Files[0].Id
+
+		for _, id := range append(fx.orphans, fx.duplicate, fx.incomplete.ID) {
+			f, err := fx.backend.Get(ctx, id)
+			assert.NoError(t, err)
+			assert.Contains(t, f.Parents, quarantine, id)
+		}
+
+		files, err := fx.service.ListFiles("")
+		assert.NoError(t, err)
+		for _, f := range files {
+			assert.NotEqual(t, fx.incomplete.ID, f.ID, "quarantined files should not be listed")
+		}
+		assert.Error(t, fx.service.Download(ctx, fx.incomplete.ID, "/incomplete.copy"))
+
+		// a second repair reuses the quarantine folder
+		orphan, err := fx.backend.CreateDoc(ctx, &drive.File{
+			Name:       "lost1",
+			MimeType:   docMimeType,
+			Properties: map[string]string{uds.ChunkProperty: "true", uds.PartProperty: "1"},
+		}, "content")
+		assert.NoError(t, err)
+		report, err = fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, report, RepairOptions{Action: RepairQuarantine, Clock: later()}))
+		f, err := fx.backend.Get(ctx, orphan.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{quarantine}, f.Parents)
+	})
+
+	t.Run("recent incomplete files", func(t *testing.T) {
+		fx := setup(t)
+		ctx := fx.service.ctx
+
+		report, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, report, RepairOptions{Action: RepairDelete}))
+		for _, p := range report.Problems {
+			assert.Equal(t, p.Kind == ProblemIncomplete, p.Recent, p.Name)
+			assert.Equal(t, p.Kind != ProblemIncomplete, p.Done, p.Name)
+		}
+
+		_, err = fx.backend.Get(ctx, fx.incomplete.ID)
+		assert.NoError(t, err, "an upload running elsewhere should be left alone")
+
+		again, err := fx.service.Scan(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, fx.service.Repair(ctx, again, RepairOptions{Action: RepairDelete, MinAge: time.Nanosecond}))
+		_, err = fx.backend.Get(ctx, fx.incomplete.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported action", func(t *testing.T) {
+		service, _, _ := setupBackend(t)
+		assert.Error(t, service.Repair(service.ctx, &ScanReport{}, RepairOptions{Action: "shred"}))
+	})
+}
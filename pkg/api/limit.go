@@ -0,0 +1,398 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	usageFile   = "usage.json"
+	usageLayout = "2006-01-02"
+)
+
+// ErrDailyBudgetExceeded is returned by uploads that would go over
+// Limits.DailyUploadBytes
+var ErrDailyBudgetExceeded = errors.New("daily upload budget exceeded")
+
+// Limits struct throttles the Drive calls of Service to stay below the per
+// user rate limits and daily upload cap. Its zero value sets no limit.
+type Limits struct {
+	// RequestsPerSecond throttles every Drive call, unlimited when zero
+	RequestsPerSecond float64
+	// BytesPerSecond throttles the chunk content uploaded, unlimited when zero
+	BytesPerSecond float64
+	// DailyUploadBytes is the budget of chunk content uploaded per UTC day,
+	// unlimited when zero
+	DailyUploadBytes int64
+	// WaitForBudget pauses uploads until the next day once the budget is
+	// spent, instead of failing with ErrDailyBudgetExceeded
+	WaitForBudget bool
+	// Clock paces the calls, the wall clock when nil
+	Clock Clock
+}
+
+func (l *Limits) clock() Clock {
+	if l.Clock == nil {
+		return wallClock{}
+	}
+	return l.Clock
+}
+
+// Usage struct counts the Drive calls made during a UTC day
+type Usage struct {
+	Day           string `json:"day"`
+	Requests      int64  `json:"requests"`
+	UploadedBytes int64  `json:"uploaded_bytes"`
+}
+
+// DefaultStateDir function returns $XDG_STATE_HOME/uds, or ~/.local/state/uds
+// when the variable is not set
+func DefaultStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "uds"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "uds"), nil
+}
+
+// Usage returns what was used so far today. It is kept in the state
+// directory, so it adds up across processes run one after the other.
+func (api *Service) Usage() (Usage, error) {
+	return api.limiter.current()
+}
+
+// Close saves the usage of the day, which is only saved every few seconds
+// while the service is used
+func (api *Service) Close() error {
+	if api.limiter == nil {
+		return nil
+	}
+	return api.limiter.flush()
+}
+
+// tokenBucket lets rate tokens per second through, with bursts of up to
+// burst tokens. Takes larger than burst are let through after the wait they
+// would have needed had the bucket been larger.
+type tokenBucket struct {
+	mu     sync.Mutex
+	clock  Clock
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(clock Clock, rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{clock: clock, rate: rate, burst: burst, tokens: burst}
+}
+
+// take removes n tokens, waiting for the bucket to refill when it runs short
+func (b *tokenBucket) take(ctx context.Context, n float64) error {
+	b.mu.Lock()
+	now := b.clock.Now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens -= n
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return b.clock.Sleep(ctx, wait)
+}
+
+// limiter enforces Limits and keeps the Usage of the day
+type limiter struct {
+	limits   *Limits
+	stateDir *string
+
+	once     sync.Once
+	requests *tokenBucket
+	bytes    *tokenBucket
+
+	mu     sync.Mutex
+	usage  Usage
+	loaded bool
+	// dirty tells usage changed since it was saved at saved
+	dirty bool
+	saved time.Time
+}
+
+// usageSaveInterval is how often the usage is saved at most while it changes
+const usageSaveInterval = 10 * time.Second
+
+func (l *limiter) init() {
+	l.once.Do(func() {
+		clock := l.limits.clock()
+		if l.limits.RequestsPerSecond > 0 {
+			l.requests = newTokenBucket(clock, l.limits.RequestsPerSecond)
+		}
+		if l.limits.BytesPerSecond > 0 {
+			l.bytes = newTokenBucket(clock, l.limits.BytesPerSecond)
+		}
+	})
+}
+
+// request waits for the turn of a Drive call and counts it
+func (l *limiter) request(ctx context.Context) error {
+	l.init()
+	if l.requests != nil {
+		if err := l.requests.take(ctx, 1); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.today(); err != nil {
+		return err
+	}
+	l.usage.Requests++
+	return l.changed()
+}
+
+// reserve books n uploaded bytes in the budget of the day, waiting for the
+// next day or failing when they do not fit. The returned func gives the
+// bytes back when the upload fails.
+func (l *limiter) reserve(ctx context.Context, n int64) (func(), error) {
+	l.init()
+	for {
+		wait, err := l.book(n)
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			break
+		}
+		if err := l.limits.clock().Sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.usage.UploadedBytes -= n
+		_ = l.changed()
+	}
+	if l.bytes != nil {
+		if err := l.bytes.take(ctx, float64(n)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// book adds n bytes to the usage of the day if they fit the budget, or
+// returns how long to wait for the next day
+func (l *limiter) book(n int64) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.today(); err != nil {
+		return 0, err
+	}
+	budget := l.limits.DailyUploadBytes
+	if budget > 0 && l.usage.UploadedBytes+n > budget {
+		if !l.limits.WaitForBudget || n > budget {
+			return 0, ErrDailyBudgetExceeded
+		}
+		now := l.limits.clock().Now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return midnight.Sub(now), nil
+	}
+
+	l.usage.UploadedBytes += n
+	return 0, l.changed()
+}
+
+func (l *limiter) current() (Usage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.today()
+	return l.usage, err
+}
+
+// today loads the usage from the state file the first time, and starts
+// over when the day changed. A state file that cannot be read, e.g. torn by
+// a crash, starts the day over as well. It must be called with mu held.
+func (l *limiter) today() error {
+	if !l.loaded && *l.stateDir != "" {
+		b, err := afero.ReadFile(AppFs, filepath.Join(*l.stateDir, usageFile))
+		if err == nil {
+			err = json.Unmarshal(b, &l.usage)
+		}
+		if err != nil {
+			l.usage = Usage{}
+		}
+	}
+	l.loaded = true
+
+	day := l.limits.clock().Now().UTC().Format(usageLayout)
+	if l.usage.Day != day {
+		l.usage = Usage{Day: day}
+	}
+	return nil
+}
+
+// changed saves the usage unless it was saved within usageSaveInterval. It
+// must be called with mu held.
+func (l *limiter) changed() error {
+	l.dirty = true
+	now := l.limits.clock().Now()
+	if !l.saved.IsZero() && now.Sub(l.saved) < usageSaveInterval {
+		return nil
+	}
+	l.saved = now
+	return l.save()
+}
+
+// flush saves the usage if it changed since it was last saved
+func (l *limiter) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty {
+		return nil
+	}
+	return l.save()
+}
+
+// save writes the usage to the state file, through a temporary file so that
+// a crash leaves either usage whole. It must be called with mu held.
+func (l *limiter) save() error {
+	if *l.stateDir == "" {
+		return nil
+	}
+	b, err := json.Marshal(l.usage)
+	if err != nil {
+		return err
+	}
+	if err := AppFs.MkdirAll(*l.stateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(*l.stateDir, usageFile)
+	tmp := path + ".tmp"
+	if err := afero.WriteFile(AppFs, tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := AppFs.Rename(tmp, path); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// limitBackend makes every call of a Backend wait for its turn in a limiter
+type limitBackend struct {
+	backend Backend
+	limiter *limiter
+}
+
+func (b *limitBackend) CreateFolder(ctx context.Context, file *drive.File) (*drive.File, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.CreateFolder(ctx, file)
+}
+
+func (b *limitBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	release, err := b.limiter.reserve(ctx, int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.limiter.request(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	f, err := b.backend.CreateDoc(ctx, file, content)
+	if err != nil {
+		release()
+	}
+	return f, err
+}
+
+func (b *limitBackend) List(ctx context.Context, q, pageToken string) (*drive.FileList, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.List(ctx, q, pageToken)
+}
+
+func (b *limitBackend) Get(ctx context.Context, id string) (*drive.File, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.Get(ctx, id)
+}
+
+func (b *limitBackend) Export(ctx context.Context, id string) (string, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return "", err
+	}
+	return b.backend.Export(ctx, id)
+}
+
+func (b *limitBackend) Delete(ctx context.Context, id string) error {
+	if err := b.limiter.request(ctx); err != nil {
+		return err
+	}
+	return b.backend.Delete(ctx, id)
+}
+
+func (b *limitBackend) UpdateProperties(ctx context.Context, id string, properties map[string]string) (*drive.File, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.UpdateProperties(ctx, id, properties)
+}
+
+func (b *limitBackend) SetTrashed(ctx context.Context, id string, trashed bool) (*drive.File, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.SetTrashed(ctx, id, trashed)
+}
+
+func (b *limitBackend) Move(ctx context.Context, id string, from []string, to string) (*drive.File, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.Move(ctx, id, from, to)
+}
+
+func (b *limitBackend) About(ctx context.Context) (*drive.About, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.About(ctx)
+}
+
+func (b *limitBackend) GenerateIDs(ctx context.Context, count int) ([]string, error) {
+	if err := b.limiter.request(ctx); err != nil {
+		return nil, err
+	}
+	return b.backend.GenerateIDs(ctx, count)
+}
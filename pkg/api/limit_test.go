@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("burst then pace", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		b := newTokenBucket(clock, 2)
+
+		assert.NoError(t, b.take(ctx, 1))
+		assert.NoError(t, b.take(ctx, 1))
+		assert.Empty(t, clock.waits)
+
+		assert.NoError(t, b.take(ctx, 1))
+		assert.Equal(t, []time.Duration{500 * time.Millisecond}, clock.waits)
+
+		// a quiet while refills the bucket, but no more than the burst
+		clock.now = clock.now.Add(10 * time.Second)
+		assert.NoError(t, b.take(ctx, 2))
+		assert.Len(t, clock.waits, 1)
+	})
+
+	t.Run("take more than the burst", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		b := newTokenBucket(clock, 100)
+
+		assert.NoError(t, b.take(ctx, 300))
+		assert.Equal(t, []time.Duration{2 * time.Second}, clock.waits)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		b := newTokenBucket(&fakeClock{}, 1)
+		assert.NoError(t, b.take(ctx, 1))
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.Equal(t, context.Canceled, b.take(ctx, 1))
+	})
+}
+
+func TestLimits(t *testing.T) {
+	setup := func(t *testing.T, size int64) (*Service, *fakeClock) {
+		service, _, afs := setupBackend(t)
+		service.Workers = 1
+		service.StateDir = "/state"
+
+		clock := &fakeClock{now: time.Date(2020, 5, 6, 23, 59, 0, 0, time.UTC)}
+		service.Limits.Clock = clock
+
+		assert.NoError(t, afs.WriteFile("/data.bin", randomBytes(size), 0600))
+		return service, clock
+	}
+	base64Len := func(n int64) int64 {
+		return int64(len(uds.Base64.Encode(make([]byte, n))))
+	}
+
+	t.Run("count usage of the day", func(t *testing.T) {
+		service, _ := setup(t, 1000)
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+
+		usage, err := service.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, "2020-05-06", usage.Day)
+		assert.Equal(t, base64Len(1000), usage.UploadedBytes)
+		assert.True(t, usage.Requests > 1)
+
+		// the state file carries the usage over to the next process
+		assert.NoError(t, service.Close())
+		next := NewServiceWithBackend(service.backend)
+		next.StateDir = service.StateDir
+		next.Limits.Clock = service.Limits.Clock
+		got, err := next.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, usage, got)
+	})
+
+	t.Run("save every few seconds", func(t *testing.T) {
+		service, clock := setup(t, 1000)
+		afs := &afero.Afero{Fs: AppFs}
+		saved := func() Usage {
+			var usage Usage
+			b, err := afs.ReadFile("/state/usage.json")
+			assert.NoError(t, err)
+			assert.NoError(t, json.Unmarshal(b, &usage))
+			return usage
+		}
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		usage, err := service.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), saved().Requests, "only the first request should be saved right away")
+
+		clock.now = clock.now.Add(time.Second)
+		_, err = service.About(service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), saved().Requests)
+
+		clock.now = clock.now.Add(usageSaveInterval)
+		_, err = service.About(service.ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, usage.Requests+2, saved().Requests)
+
+		exists, err := afs.Exists("/state/usage.json.tmp")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("torn state file", func(t *testing.T) {
+		service, _ := setup(t, 1000)
+		afs := &afero.Afero{Fs: AppFs}
+		assert.NoError(t, afs.WriteFile("/state/usage.json", []byte(`{"day": "2020-05-06", "requ`), 0600))
+
+		usage, err := service.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, Usage{Day: "2020-05-06"}, usage)
+
+		_, err = service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		assert.NoError(t, service.Close())
+		b, err := afs.ReadFile("/state/usage.json")
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(b, &usage), "the state file should be whole again")
+	})
+
+	t.Run("refuse over budget", func(t *testing.T) {
+		service, _ := setup(t, uds.ChunkReadLengthBytes+1)
+		service.Limits.DailyUploadBytes = base64Len(uds.ChunkReadLengthBytes)
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.Equal(t, ErrDailyBudgetExceeded, err)
+
+		usage, err := service.Usage()
+		assert.NoError(t, err)
+		assert.True(t, usage.UploadedBytes <= service.Limits.DailyUploadBytes, usage.UploadedBytes)
+	})
+
+	t.Run("wait for the next day", func(t *testing.T) {
+		service, clock := setup(t, uds.ChunkReadLengthBytes+1)
+		service.Limits.DailyUploadBytes = base64Len(uds.ChunkReadLengthBytes)
+		service.Limits.WaitForBudget = true
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []time.Duration{time.Minute}, clock.waits)
+
+		usage, err := service.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, "2020-05-07", usage.Day)
+		assert.Equal(t, base64Len(1), usage.UploadedBytes)
+	})
+
+	t.Run("throttle requests and bytes", func(t *testing.T) {
+		service, clock := setup(t, 3*uds.ChunkReadLengthBytes)
+		service.Limits.RequestsPerSecond = 1
+		service.Limits.BytesPerSecond = float64(base64Len(uds.ChunkReadLengthBytes))
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+
+		// one chunk per second after the first
+		assert.True(t, clock.total() >= 2*time.Second, clock.total())
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// Migrate rewrites the properties of media folders written with an older
+// schema version, including the ones early releases tagged as UDS root, so
+// they show up in ListFiles. It returns the number of updated folders.
+func (api *Service) Migrate(ctx context.Context) (int, error) {
+	outdated := make(map[string]*drive.File)
+	var ids []string
+
+	for _, q := range []*Query{
+		NewQuery().Property(uds.RootProperty, "true").Trashed(false),
+		NewQuery().Property(uds.MediaProperty, "true").Trashed(false),
+	} {
+		err := api.listAll(ctx, q.String(), func(f *drive.File) error {
+			meta, err := uds.ParseMetadata(f.Properties)
+			if err != nil || !meta.Outdated() {
+				return nil
+			}
+			if _, ok := outdated[f.Id]; !ok {
+				outdated[f.Id] = f
+				ids = append(ids, f.Id)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for i, id := range ids {
+		f := outdated[id]
+		meta, _ := uds.ParseMetadata(f.Properties)
+
+		props := meta.Properties()
+		if f.Properties[uds.RootProperty] == "true" {
+			props[uds.RootProperty] = "false"
+		}
+		if _, err := api.backend.UpdateProperties(ctx, id, props); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
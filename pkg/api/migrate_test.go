@@ -0,0 +1,85 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestMigrate(t *testing.T) {
+	service, backend, _ := setupBackend(t)
+
+	root, err := service.GetBaseFolder()
+	assert.NoError(t, err)
+
+	// written by the media folder bug of early releases
+	legacyRoot, err := backend.CreateFolder(service.ctx, &drive.File{
+		Name:    "legacy.bin",
+		Parents: []string{root.Id},
+		Properties: map[string]string{
+			"udsRoot":      "true",
+			"size":         "1.0 KB",
+			"size_numeric": "1024",
+			"encoded_size": "1.3 KB",
+			"md5":          "md5-1",
+		},
+	})
+	assert.NoError(t, err)
+
+	// written by the python implementation
+	unversioned, err := backend.CreateFolder(service.ctx, &drive.File{
+		Name:    "python.bin",
+		Parents: []string{root.Id},
+		Properties: map[string]string{
+			"uds":          "true",
+			"size_numeric": "2048",
+			"md5":          "md5-2",
+		},
+	})
+	assert.NoError(t, err)
+
+	current, err := service.CreateMediaFolder(uds.NewFile("current.bin", "", 10, "md5-3", []string{root.Id}))
+	assert.NoError(t, err)
+
+	got, err := service.GetBaseFolder()
+	assert.NoError(t, err)
+	assert.Equal(t, root.Id, got.Id, "legacy media folder should not count as root")
+
+	files, err := service.ListFiles("")
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	n, err := service.Migrate(service.ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	files, err = service.ListFiles("")
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"legacy.bin", "python.bin", "current.bin"}, names)
+
+	for _, id := range []string{legacyRoot.Id, unversioned.Id, current.Id} {
+		f, err := backend.Get(service.ctx, id)
+		assert.NoError(t, err)
+
+		meta, err := uds.ParseMetadata(f.Properties)
+		assert.NoError(t, err)
+		assert.False(t, meta.Outdated())
+	}
+
+	f, err := backend.Get(service.ctx, legacyRoot.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "false", f.Properties["udsRoot"])
+	assert.Equal(t, "1024", f.Properties["size_numeric"])
+	assert.Equal(t, "md5-1", f.Properties["md5"])
+
+	n, err = service.Migrate(service.ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultWorkers is the number of chunks transferred at a time when
+// Service.Workers is not set
+const DefaultWorkers = 4
+
+func (api *Service) workers() int {
+	if api.Workers <= 0 {
+		return DefaultWorkers
+	}
+	return api.Workers
+}
+
+// runParallel calls fn for every part in [0, n) with up to workers calls
+// running at a time. The context given to fn is cancelled as soon as one call
+// fails, and the first error is returned.
+func runParallel(ctx context.Context, n int64, workers int, fn func(ctx context.Context, part int64) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once  sync.Once
+		first error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			cancel()
+		})
+	}
+
+	parts := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(ctx, part); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for part := int64(0); part < n; part++ {
+		select {
+		case parts <- part:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
+
+type fetched struct {
+	b   []byte
+	err error
+}
+
+// prefetch starts fetching every part in [from, n) in the background, with
+// up to workers fetches running at a time. The returned channel yields one
+// future per part in order, so results can be consumed in sequence while
+// later parts are still on their way. It is closed early once ctx is done.
+func prefetch(ctx context.Context, from, n int64, workers int, fetch func(part int64) ([]byte, error)) <-chan chan fetched {
+	// the consumer holds one future besides the queued ones
+	futures := make(chan chan fetched, workers-1)
+	go func() {
+		defer close(futures)
+		for part := from; part < n; part++ {
+			future := make(chan fetched, 1)
+			select {
+			case futures <- future:
+			case <-ctx.Done():
+				return
+			}
+			go func(part int64) {
+				b, err := fetch(part)
+				future <- fetched{b: b, err: err}
+			}(part)
+		}
+	}()
+	return futures
+}
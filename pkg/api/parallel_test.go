@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// gauge tracks how many calls run at the same time
+type gauge struct {
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (g *gauge) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active++
+	if g.active > g.max {
+		g.max = g.active
+	}
+}
+
+func (g *gauge) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+}
+
+// slowBackend delays chunk transfers, and fails the ones named in fail
+type slowBackend struct {
+	Backend
+	gauge
+	fail map[string]bool
+}
+
+func (b *slowBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	b.enter()
+	defer b.leave()
+	time.Sleep(10 * time.Millisecond)
+	if b.fail[file.Name] {
+		return nil, errors.New("create failed")
+	}
+	return b.Backend.CreateDoc(ctx, file, content)
+}
+
+func (b *slowBackend) Export(ctx context.Context, id string) (string, error) {
+	b.enter()
+	defer b.leave()
+	time.Sleep(10 * time.Millisecond)
+	if b.fail[id] {
+		return "", errors.New("export failed")
+	}
+	return b.Backend.Export(ctx, id)
+}
+
+func TestRunParallel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("every part once", func(t *testing.T) {
+		var (
+			g    gauge
+			mu   sync.Mutex
+			seen = map[int64]int{}
+		)
+		err := runParallel(ctx, 20, 3, func(ctx context.Context, part int64) error {
+			g.enter()
+			defer g.leave()
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			seen[part]++
+			mu.Unlock()
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, seen, 20)
+		for part, n := range seen {
+			assert.Equal(t, 1, n, part)
+		}
+		assert.True(t, g.max <= 3, g.max)
+		assert.True(t, g.max > 1, "parts should run concurrently")
+	})
+
+	t.Run("cancel on failure", func(t *testing.T) {
+		want := errors.New("part 2 failed")
+		err := runParallel(ctx, 100, 4, func(ctx context.Context, part int64) error {
+			if part == 2 {
+				return want
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.Equal(t, want, err)
+	})
+
+	t.Run("cancelled by caller", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		called := false
+		err := runParallel(ctx, 10, 2, func(ctx context.Context, part int64) error {
+			called = true
+			return nil
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.False(t, called)
+	})
+}
+
+func TestPrefetch(t *testing.T) {
+	t.Run("ordered results", func(t *testing.T) {
+		var g gauge
+		futures := prefetch(context.Background(), 2, 12, 3, func(part int64) ([]byte, error) {
+			g.enter()
+			defer g.leave()
+			// later parts finish first
+			time.Sleep(time.Duration(12-part) * time.Millisecond)
+			return []byte{byte(part)}, nil
+		})
+
+		var got []byte
+		for future := range futures {
+			res := <-future
+			assert.NoError(t, res.err)
+			got = append(got, res.b...)
+		}
+		assert.Equal(t, []byte{2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, got)
+		assert.True(t, g.max <= 3, g.max)
+	})
+
+	t.Run("stop when done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		futures := prefetch(ctx, 0, 1000, 2, func(part int64) ([]byte, error) {
+			return nil, nil
+		})
+		<-futures
+		cancel()
+
+		n := 0
+		for range futures {
+			n++
+		}
+		assert.True(t, n <= 2, n)
+	})
+}
+
+func TestParallelTransfer(t *testing.T) {
+	setup := func(t *testing.T, workers int) (*Service, *slowBackend, []byte) {
+		service, backend, afs := setupBackend(t)
+		slow := &slowBackend{Backend: backend, fail: map[string]bool{}}
+		service.backend = slow
+		service.Workers = workers
+
+		data := randomBytes(6*uds.ChunkReadLengthBytes + 1)
+		assert.NoError(t, afs.WriteFile("/data.bin", data, 0600))
+		return service, slow, data
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		service, slow, data := setup(t, 3)
+
+		media, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		assert.True(t, slow.max > 1 && slow.max <= 3, slow.max)
+
+		slow.max = 0
+		r, err := service.Open(service.ctx, media.ID)
+		assert.NoError(t, err)
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+		assert.NoError(t, r.Close())
+		assert.True(t, slow.max > 1 && slow.max <= 3, slow.max)
+	})
+
+	t.Run("upload failure", func(t *testing.T) {
+		service, slow, _ := setup(t, 2)
+		slow.fail["data.bin3"] = true
+
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.EqualError(t, err, "create failed")
+	})
+
+	t.Run("download failure", func(t *testing.T) {
+		service, slow, _ := setup(t, 4)
+
+		media, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		docs, err := service.listChunks(service.ctx, media.ID, 7)
+		assert.NoError(t, err)
+		slow.fail[docs[2].Id] = true
+
+		r, err := service.Open(service.ctx, media.ID)
+		assert.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		assert.EqualError(t, err, "export failed")
+
+		_, err = r.Read(make([]byte, 1))
+		assert.EqualError(t, err, "export failed", "errors should stick")
+		assert.NoError(t, r.Close())
+	})
+}
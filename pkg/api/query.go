@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// Query builds Drive search expressions out of composable filters. Values are
+// escaped, so user input can be passed as is. Filters Drive cannot evaluate,
+// like size ranges over the size_numeric property, are applied by Match.
+type Query struct {
+	terms   []string
+	filters []func(*drive.File) bool
+}
+
+// NewQuery function returns an empty Query matching every file
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// And adds every filter of other to q
+func (q *Query) And(other *Query) *Query {
+	if other != nil {
+		q.terms = append(q.terms, other.terms...)
+		q.filters = append(q.filters, other.filters...)
+	}
+	return q
+}
+
+// Property matches files having the property key set to value
+func (q *Query) Property(key, value string) *Query {
+	return q.term("properties has {key='%s' and value='%s'}", escape(key), escape(value))
+}
+
+// Trashed matches files by their trashed state
+func (q *Query) Trashed(trashed bool) *Query {
+	return q.term("trashed=%t", trashed)
+}
+
+// NameContains matches files whose name contains s
+func (q *Query) NameContains(s string) *Query {
+	return q.term("name contains '%s'", escape(s))
+}
+
+// NameEquals matches files named s
+func (q *Query) NameEquals(s string) *Query {
+	return q.term("name = '%s'", escape(s))
+}
+
+// MimeType matches files of the given Drive mime type
+func (q *Query) MimeType(mimeType string) *Query {
+	return q.term("mimeType = '%s'", escape(mimeType))
+}
+
+// Parent matches files directly inside the folder id
+func (q *Query) Parent(id string) *Query {
+	return q.term("'%s' in parents", escape(id))
+}
+
+// ModifiedAfter matches files modified after t
+func (q *Query) ModifiedAfter(t time.Time) *Query {
+	return q.term("modifiedTime > '%s'", t.UTC().Format(time.RFC3339))
+}
+
+// Shared matches files by whether they are shared with others
+func (q *Query) Shared(shared bool) *Query {
+	return q.filter(func(f *drive.File) bool {
+		return f.Shared == shared
+	})
+}
+
+// MinSize matches UDS files holding at least size bytes
+func (q *Query) MinSize(size int64) *Query {
+	return q.filter(func(f *drive.File) bool {
+		n, err := strconv.ParseInt(f.Properties[uds.SizeNumericProperty], 10, 64)
+		return err == nil && n >= size
+	})
+}
+
+// MaxSize matches UDS files holding at most size bytes
+func (q *Query) MaxSize(size int64) *Query {
+	return q.filter(func(f *drive.File) bool {
+		n, err := strconv.ParseInt(f.Properties[uds.SizeNumericProperty], 10, 64)
+		return err == nil && n <= size
+	})
+}
+
+// String returns the search expression sent to Drive
+func (q *Query) String() string {
+	return strings.Join(q.terms, " and ")
+}
+
+// Match reports whether f passes the filters Drive cannot evaluate
+func (q *Query) Match(f *drive.File) bool {
+	for _, filter := range q.filters {
+		if !filter(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *Query) term(format string, args ...interface{}) *Query {
+	q.terms = append(q.terms, fmt.Sprintf(format, args...))
+	return q
+}
+
+func (q *Query) filter(fn func(*drive.File) bool) *Query {
+	q.filters = append(q.filters, fn)
+	return q
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestQuery(t *testing.T) {
+	t.Run("build expression", func(t *testing.T) {
+		for _, tc := range []struct {
+			description string
+			given       *Query
+			want        string
+		}{
+			{"empty", NewQuery(), ""},
+			{"property", NewQuery().Property("uds", "true"), `properties has {key='uds' and value='true'}`},
+			{"trashed", NewQuery().Trashed(false), `trashed=false`},
+			{"name contains", NewQuery().NameContains(`it's`), `name contains 'it\'s'`},
+			{"name equals", NewQuery().NameEquals(`a\b`), `name = 'a\\b'`},
+			{"mime type", NewQuery().MimeType(folderMimeType), `mimeType = 'application/vnd.google-apps.folder'`},
+			{"parent", NewQuery().Parent("folder-1"), `'folder-1' in parents`},
+			{
+				"modified after",
+				NewQuery().ModifiedAfter(time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("KST", 9*60*60))),
+				`modifiedTime > '2020-01-01T18:04:05Z'`,
+			},
+			{
+				"composed",
+				NewQuery().Property("uds", "true").Trashed(false).And(NewQuery().NameContains("x")).And(nil),
+				`properties has {key='uds' and value='true'} and trashed=false and name contains 'x'`,
+			},
+			{"client side only", NewQuery().Shared(true).MinSize(1).MaxSize(2), ""},
+		} {
+			t.Run(tc.description, func(t *testing.T) {
+				assert.Equal(t, tc.want, tc.given.String())
+
+				_, err := drivetest.ParseQuery(tc.given.String())
+				assert.NoError(t, err, "expression should be understood by drive")
+			})
+		}
+	})
+
+	t.Run("match client side filters", func(t *testing.T) {
+		file := &drive.File{Shared: true, Properties: map[string]string{"size_numeric": "100"}}
+
+		for _, tc := range []struct {
+			description string
+			given       *Query
+			want        bool
+		}{
+			{"empty", NewQuery(), true},
+			{"server side terms are ignored", NewQuery().NameContains("x"), true},
+			{"shared", NewQuery().Shared(true), true},
+			{"not shared", NewQuery().Shared(false), false},
+			{"size in range", NewQuery().MinSize(100).MaxSize(100), true},
+			{"too small", NewQuery().MinSize(101), false},
+			{"too large", NewQuery().MaxSize(99), false},
+		} {
+			t.Run(tc.description, func(t *testing.T) {
+				assert.Equal(t, tc.want, tc.given.Match(file))
+			})
+		}
+
+		assert.False(t, NewQuery().MinSize(0).Match(&drive.File{}), "size_numeric is required")
+		assert.False(t, NewQuery().MaxSize(0).Match(&drive.File{}), "size_numeric is required")
+	})
+
+	t.Run("list with filters", func(t *testing.T) {
+		service, _, _ := setupBackend(t)
+
+		for _, given := range []struct {
+			name string
+			size int64
+		}{
+			{"it's mine.txt", 10},
+			{"its.txt", 2000},
+			{"other.bin", 3000},
+		} {
+			_, err := service.CreateMediaFolder(uds.NewFile(given.name, "", given.size, "", nil))
+			assert.NoError(t, err)
+		}
+
+		files, err := service.ListFiles("it's")
+		assert.NoError(t, err)
+		if assert.Len(t, files, 1) {
+			assert.Equal(t, "10", files[0].SizeNumeric)
+		}
+
+		var sizes []string
+		err = service.ListFilesIter(service.ctx, ListOptions{Filter: NewQuery().MinSize(1000)}, func(f *uds.File) error {
+			sizes = append(sizes, f.SizeNumeric)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"2000", "3000"}, sizes)
+	})
+}
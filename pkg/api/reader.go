@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+const readerCacheSize = 4
+
+var errNegativeOffset = errors.New("uds: negative offset")
+
+// Reader gives random access to a stored UDS file. Only the chunk Docs that
+// cover the requested ranges are fetched, and a few recently decoded chunks are
+// kept in memory, so it can back http.ServeContent for range requests.
+type Reader struct {
+	api       *Service
+	ctx       context.Context
+	media     *uds.File
+	transform *transform
+	size      int64
+	docs      []*drive.File
+
+	mu       sync.Mutex
+	cache    *chunkCache
+	fetching map[int64]*fetch
+	offset   int64
+	closed   bool
+}
+
+// fetch is the download of a chunk Doc, shared by every read waiting for it
+type fetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewReader returns a Reader over the UDS file id
+func (api *Service) NewReader(ctx context.Context, id string) (*Reader, error) {
+	folder, meta, err := api.getMedia(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := api.openTransform(folder, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := api.listChunks(ctx, id, t.numChunks(meta.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		api:       api,
+		ctx:       ctx,
+		media:     &uds.File{Name: folder.Name, ID: folder.Id, MD5: meta.MD5},
+		transform: t,
+		size:      meta.Size,
+		docs:      docs,
+		cache:     newChunkCache(readerCacheSize),
+		fetching:  make(map[int64]*fetch),
+	}, nil
+}
+
+// Size returns the length of the file in bytes
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	n := 0
+	for n < len(p) && off < r.size {
+		b, start, err := r.chunk(off / r.transform.codec.ChunkSize())
+		if err != nil {
+			return n, err
+		}
+		k := copy(p[n:], b[off-start:])
+		n += k
+		off += int64(k)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	off := r.offset
+	r.mu.Unlock()
+
+	n, err := r.ReadAt(p, off)
+
+	r.mu.Lock()
+	r.offset = off + int64(n)
+	r.mu.Unlock()
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("uds: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close releases the cached chunks
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errClosed
+	}
+	r.closed = true
+	r.cache.clear()
+	return nil
+}
+
+// chunk returns the decoded bytes of part and the offset they start at. The
+// lock is only held to look up and fill the cache, so that reads of other
+// chunks and seeks go on while a Doc is downloaded; reads of the same chunk
+// wait for that download instead of starting another.
+func (r *Reader) chunk(part int64) ([]byte, int64, error) {
+	c := r.transform.chunk(r.media, part, r.size)
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, 0, errClosed
+	}
+	if b, ok := r.cache.get(part); ok {
+		r.mu.Unlock()
+		return b, c.RangeStart(), nil
+	}
+	f, ok := r.fetching[part]
+	if !ok {
+		f = &fetch{done: make(chan struct{})}
+		r.fetching[part] = f
+	}
+	r.mu.Unlock()
+
+	if ok {
+		<-f.done
+		return f.data, c.RangeStart(), f.err
+	}
+
+	f.data, f.err = r.api.downloadChunk(r.ctx, c, r.docs[part])
+
+	r.mu.Lock()
+	delete(r.fetching, part)
+	if f.err == nil && !r.closed {
+		r.cache.add(part, f.data)
+	}
+	r.mu.Unlock()
+	close(f.done)
+
+	return f.data, c.RangeStart(), f.err
+}
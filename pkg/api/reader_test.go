@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// exportCounter counts the exports of every Doc, and holds the ones in hold
+// until their channel is closed
+type exportCounter struct {
+	Backend
+	mu      sync.Mutex
+	exports map[string]int
+	hold    map[string]chan struct{}
+}
+
+func (b *exportCounter) Export(ctx context.Context, id string) (string, error) {
+	b.mu.Lock()
+	b.exports[id]++
+	hold := b.hold[id]
+	b.mu.Unlock()
+
+	if hold != nil {
+		<-hold
+	}
+	return b.Backend.Export(ctx, id)
+}
+
+func (b *exportCounter) count(id string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exports[id]
+}
+
+func TestReader(t *testing.T) {
+	setup := func(t *testing.T, data []byte) (*Reader, *exportCounter) {
+		service, backend, afs := setupBackend(t)
+
+		assert.NoError(t, afs.WriteFile("/archive.tar", data, 0600))
+		media, err := service.Upload(service.ctx, "/archive.tar", "")
+		assert.NoError(t, err)
+
+		counter := &exportCounter{Backend: backend, exports: map[string]int{}}
+		service.backend = counter
+
+		r, err := service.NewReader(service.ctx, media.ID)
+		assert.NoError(t, err)
+		return r, counter
+	}
+
+	data := randomBytes(3*uds.ChunkReadLengthBytes + 5)
+
+	t.Run("read at fetches only needed chunks", func(t *testing.T) {
+		r, counter := setup(t, data)
+		assert.Equal(t, int64(len(data)), r.Size())
+
+		off := 2*uds.ChunkReadLengthBytes - 10
+		p := make([]byte, 20)
+		n, err := r.ReadAt(p, off)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, n)
+		assert.Equal(t, data[off:off+20], p)
+		assert.Len(t, counter.exports, 2)
+
+		n, err = r.ReadAt(p, off+5)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, n)
+		assert.Equal(t, data[off+5:off+25], p)
+		for _, count := range counter.exports {
+			assert.Equal(t, 1, count, "cached chunk should not be fetched again")
+		}
+
+		n, err = r.ReadAt(p, int64(len(data))-3)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, data[len(data)-3:], p[:n])
+
+		_, err = r.ReadAt(p, -1)
+		assert.Error(t, err)
+	})
+
+	t.Run("seek and read", func(t *testing.T) {
+		r, counter := setup(t, data)
+
+		pos, err := r.Seek(-5, io.SeekEnd)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data))-5, pos)
+
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data[pos:], got)
+		assert.Len(t, counter.exports, 1, "only the tail chunk should be fetched")
+
+		pos, err = r.Seek(0, io.SeekStart)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), pos)
+
+		pos, err = r.Seek(10, io.SeekCurrent)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), pos)
+
+		_, err = r.Seek(-11, io.SeekCurrent)
+		assert.Error(t, err)
+		_, err = r.Seek(0, 42)
+		assert.Error(t, err)
+
+		got, err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data[10:], got)
+
+		assert.NoError(t, r.Close())
+		assert.Error(t, r.Close())
+		_, err = r.ReadAt(make([]byte, 1), 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("fetch without blocking other reads", func(t *testing.T) {
+		r, counter := setup(t, data)
+		first := r.docs[0].Id
+		release := make(chan struct{})
+		counter.hold = map[string]chan struct{}{first: release}
+
+		var wg sync.WaitGroup
+		got := make([][]byte, 2)
+		for i := range got {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				got[i] = make([]byte, 10)
+				_, err := r.ReadAt(got[i], 0)
+				assert.NoError(t, err)
+			}(i)
+		}
+		assert.Eventually(t, func() bool {
+			return counter.count(first) == 1
+		}, time.Second, time.Millisecond)
+
+		// while the first chunk is downloaded, others are read and seeks go on
+		p := make([]byte, 10)
+		_, err := r.ReadAt(p, uds.ChunkReadLengthBytes)
+		assert.NoError(t, err)
+		assert.Equal(t, data[uds.ChunkReadLengthBytes:uds.ChunkReadLengthBytes+10], p)
+		_, err = r.Seek(5, io.SeekStart)
+		assert.NoError(t, err)
+
+		close(release)
+		wg.Wait()
+		for _, b := range got {
+			assert.Equal(t, data[:10], b)
+		}
+		assert.Equal(t, 1, counter.count(first), "concurrent reads should share the download")
+	})
+
+	t.Run("serve range request", func(t *testing.T) {
+		r, _ := setup(t, data)
+
+		req := httptest.NewRequest(http.MethodGet, "/archive.tar", nil)
+		req.Header.Set("Range", "bytes=1000-1999")
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "archive.tar", time.Time{}, r)
+
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.True(t, bytes.Equal(data[1000:2000], rec.Body.Bytes()))
+	})
+}
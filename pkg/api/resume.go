@@ -0,0 +1,326 @@
+package api
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+const journalDir = "uploads"
+
+// ErrNothingToResume is returned by ResumeUpload when no unfinished upload of
+// the file is journaled
+var ErrNothingToResume = errors.New("no unfinished upload to resume")
+
+// journal records the progress of an upload, so that it can be resumed after
+// the process died
+type journal struct {
+	Path     string  `json:"path"`
+	MD5      string  `json:"md5"`
+	FolderID string  `json:"folder_id"`
+	Parts    []int64 `json:"completed_parts"`
+	// Encoded is the size of the text of the chunk Doc of every completed part
+	Encoded map[int64]int64 `json:"encoded_sizes,omitempty"`
+
+	mu   sync.Mutex
+	file string
+}
+
+// journalFile returns where the journal of the upload of path is kept, or
+// an empty string when there is no state directory
+func (api *Service) journalFile(path string) (string, error) {
+	if api.StateDir == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum([]byte(abs))
+	return filepath.Join(api.StateDir, journalDir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func (api *Service) newJournal(path string, media *uds.File) (*journal, error) {
+	file, err := api.journalFile(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{Path: abs, MD5: media.MD5, FolderID: media.ID, file: file}, nil
+}
+
+// loadJournal returns the journal of the unfinished upload of path
+func (api *Service) loadJournal(path string) (*journal, error) {
+	file, err := api.journalFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return nil, ErrNothingToResume
+	}
+
+	b, err := afero.ReadFile(AppFs, file)
+	if os.IsNotExist(err) {
+		return nil, ErrNothingToResume
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{file: file}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("corrupt upload journal %s: %v", file, err)
+	}
+	return j, nil
+}
+
+// journaledFolders returns the ids of the media folders of every journaled
+// upload, which ResumeUpload can still complete
+func (api *Service) journaledFolders() (map[string]bool, error) {
+	folders := make(map[string]bool)
+	if api.StateDir == "" {
+		return folders, nil
+	}
+
+	dir := filepath.Join(api.StateDir, journalDir)
+	infos, err := afero.ReadDir(AppFs, dir)
+	if os.IsNotExist(err) {
+		return folders, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		b, err := afero.ReadFile(AppFs, filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var j journal
+		if err := json.Unmarshal(b, &j); err != nil {
+			return nil, fmt.Errorf("corrupt upload journal %s: %v", info.Name(), err)
+		}
+		folders[j.FolderID] = true
+	}
+	return folders, nil
+}
+
+// complete records that part was uploaded as a Doc of encoded bytes
+func (j *journal) complete(part, encoded int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Parts = append(j.Parts, part)
+	if j.Encoded == nil {
+		j.Encoded = make(map[int64]int64)
+	}
+	j.Encoded[part] = encoded
+	sort.Slice(j.Parts, func(a, b int) bool {
+		return j.Parts[a] < j.Parts[b]
+	})
+	return j.write()
+}
+
+func (j *journal) save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.write()
+}
+
+// write replaces the journal file at once, so that a crash never leaves a
+// torn one behind. It must be called with mu held.
+func (j *journal) write() error {
+	if j.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := AppFs.MkdirAll(filepath.Dir(j.file), 0700); err != nil {
+		return err
+	}
+	tmp := j.file + ".tmp"
+	if err := afero.WriteFile(AppFs, tmp, b, 0600); err != nil {
+		return err
+	}
+	return AppFs.Rename(tmp, j.file)
+}
+
+func (j *journal) remove() error {
+	if j.file == "" {
+		return nil
+	}
+	if err := AppFs.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ResumeUpload completes the journaled upload of the local file at path that
+// failed or was interrupted. The chunk Docs already in its media folder are
+// kept, and only the missing parts are uploaded. It fails when the file
+// changed since, and returns ErrNothingToResume when there is no such upload.
+func (api *Service) ResumeUpload(ctx context.Context, path string) (*uds.File, error) {
+	j, err := api.loadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := AppFs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sum, _, err := checksums(f)
+	if err != nil {
+		return nil, err
+	}
+	if sum != j.MD5 {
+		return nil, fmt.Errorf("%s changed since its upload started, push it again", path)
+	}
+
+	folder, meta, err := api.getMedia(ctx, j.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	if meta.MD5 != j.MD5 {
+		return nil, fmt.Errorf("media folder %s does not hold %s", j.FolderID, path)
+	}
+	t, err := api.openTransform(folder, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := api.uploadedParts(ctx, folder.Id, t.numChunks(meta.Size))
+	if err != nil {
+		return nil, err
+	}
+	media := meta.File(folder.Name, folder.Id, folder.Parents)
+	var missing []int64
+	checked := t.cipher == nil
+	for part, doc := range docs {
+		if doc == nil {
+			missing = append(missing, int64(part))
+			continue
+		}
+		if !checked {
+			// parts sealed with another key could never be read back
+			chunk := t.chunk(media, int64(part), meta.Size)
+			if _, err := api.downloadChunk(ctx, chunk, doc); err != nil {
+				return nil, fmt.Errorf("part %d already uploaded does not open, is the passphrase the one of the first attempt? %v", part, err)
+			}
+			checked = true
+		}
+	}
+
+	if err := api.uploadParts(ctx, f, path, media, meta.Size, t, missing, j); err != nil {
+		return nil, err
+	}
+	if err := api.recordEncodedSize(ctx, media, meta, j, int64(len(docs))); err != nil {
+		return nil, err
+	}
+	return media, j.remove()
+}
+
+// uploadedParts returns the chunk Doc of each of the count parts of a media
+// folder, nil for the missing ones. When a call was retried after it went
+// through and left several Docs for a part, the oldest one whose text matches
+// its checksum is kept and the others are deleted; Drive lists them in no
+// particular order. A part none of whose Docs matches is missing.
+func (api *Service) uploadedParts(ctx context.Context, folderID string, count int64) ([]*drive.File, error) {
+	copies := make([][]*drive.File, count)
+
+	q := NewQuery().Parent(folderID).Trashed(false)
+	err := api.listAll(ctx, q.String(), func(f *drive.File) error {
+		part, err := uds.ParsePart(f.Properties)
+		if err != nil || part >= count {
+			return fmt.Errorf("unexpected chunk %s (%s) in %s", f.Name, f.Id, folderID)
+		}
+		copies[part] = append(copies[part], f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*drive.File, count)
+	for part, files := range copies {
+		if len(files) < 2 {
+			if len(files) == 1 {
+				docs[part] = files[0]
+			}
+			continue
+		}
+
+		sort.SliceStable(files, func(a, b int) bool {
+			return createdTime(files[a]).Before(createdTime(files[b]))
+		})
+		for _, f := range files {
+			if docs[part] == nil {
+				ok, err := api.intactChunk(ctx, f)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					docs[part] = f
+					continue
+				}
+			}
+			if err := api.backend.Delete(ctx, f.Id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+// intactChunk reports whether the text of the chunk Doc f matches the
+// checksum recorded on it. Docs stored before checksums were recorded cannot
+// be told apart, and are taken as intact.
+func (api *Service) intactChunk(ctx context.Context, f *drive.File) (bool, error) {
+	sum := f.Properties[uds.SHA256Property]
+	if sum == "" {
+		return true, nil
+	}
+	content, err := api.backend.Export(ctx, f.Id)
+	if err != nil {
+		return false, err
+	}
+	part, err := uds.ParsePart(f.Properties)
+	if err != nil {
+		return false, err
+	}
+	return uds.Checksum(part, content) == sum, nil
+}
+
+// createdTime returns the createdTime of f, zero when unknown
+func createdTime(f *drive.File) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, f.CreatedTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// crashingBackend fails the creation of the chunk Docs named in fail
+type crashingBackend struct {
+	Backend
+	mu      sync.Mutex
+	fail    map[string]bool
+	created []string
+}
+
+func (b *crashingBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	b.mu.Lock()
+	fail := b.fail[file.Name]
+	if !fail {
+		b.created = append(b.created, file.Name)
+	}
+	b.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("crashed")
+	}
+	return b.Backend.CreateDoc(ctx, file, content)
+}
+
+// reversedBackend lists files in the reverse order
+type reversedBackend struct {
+	Backend
+}
+
+func (b *reversedBackend) List(ctx context.Context, q, pageToken string) (*drive.FileList, error) {
+	r, err := b.Backend.List(ctx, q, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(r.Files)-1; i < j; i, j = i+1, j-1 {
+		r.Files[i], r.Files[j] = r.Files[j], r.Files[i]
+	}
+	return r, nil
+}
+
+func TestResumeUpload(t *testing.T) {
+	setup := func(t *testing.T, data []byte) (*Service, *crashingBackend) {
+		service, backend, afs := setupBackend(t)
+		crashing := &crashingBackend{Backend: backend, fail: map[string]bool{"data.bin2": true}}
+		service.backend = crashing
+		service.StateDir = "/state"
+		service.Workers = 1
+
+		assert.NoError(t, afs.WriteFile("/data.bin", data, 0600))
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.EqualError(t, err, "crashed")
+
+		crashing.fail = nil
+		crashing.created = nil
+		return service, crashing
+	}
+
+	t.Run("upload missing parts only", func(t *testing.T) {
+		data := randomBytes(4*uds.ChunkReadLengthBytes + 3)
+		service, crashing := setup(t, data)
+
+		j, err := service.loadJournal("/data.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, "/data.bin", j.Path)
+		assert.Equal(t, []int64{0, 1}, j.Parts)
+		assert.NotEmpty(t, j.FolderID)
+
+		media, err := service.ResumeUpload(service.ctx, "/data.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, j.FolderID, media.ID)
+		assert.Equal(t, "data.bin", media.Name)
+		assert.Equal(t, []string{"data.bin2", "data.bin3", "data.bin4"}, crashing.created)
+
+		// the sizes of the parts of the first attempt are journaled
+		folder, err := crashing.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "4000004", folder.Properties[uds.EncodedSizeNumericProperty])
+		assert.Equal(t, "3.8 MB", media.EncodedSize)
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/copy.bin"))
+		got, err := (&afero.Afero{Fs: AppFs}).ReadFile("/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		_, err = service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Equal(t, ErrNothingToResume, err, "journal should be removed once done")
+	})
+
+	t.Run("drop duplicated parts", func(t *testing.T) {
+		data := randomBytes(3*uds.ChunkReadLengthBytes + 3)
+		service, crashing := setup(t, data)
+
+		j, err := service.loadJournal("/data.bin")
+		assert.NoError(t, err)
+		docs, err := service.uploadedParts(service.ctx, j.FolderID, 4)
+		assert.NoError(t, err)
+		original := docs[1]
+
+		// a broken leftover claiming the checksum of the original, listed
+		// first as Drive may do
+		_, err = crashing.Backend.CreateDoc(service.ctx, &drive.File{
+			Name:       original.Name,
+			Parents:    []string{j.FolderID},
+			Properties: original.Properties,
+		}, "leftover")
+		assert.NoError(t, err)
+		crashing.Backend = &reversedBackend{Backend: crashing.Backend}
+
+		media, err := service.ResumeUpload(service.ctx, "/data.bin")
+		assert.NoError(t, err)
+
+		docs, err = service.uploadedParts(service.ctx, media.ID, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, original.Id, docs[1].Id)
+
+		r, err := service.Open(service.ctx, media.ID)
+		assert.NoError(t, err)
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+		crashing := &crashingBackend{Backend: backend, fail: map[string]bool{"data.bin1": true}}
+		service.backend = crashing
+		service.StateDir = "/state"
+		service.Workers = 1
+		service.Passphrase = []byte("secret")
+
+		data := randomBytes(2*uds.ChunkReadLengthBytes + 3)
+		assert.NoError(t, afs.WriteFile("/data.bin", data, 0600))
+		_, err := service.Upload(service.ctx, "/data.bin", "")
+		assert.Error(t, err)
+		crashing.fail = nil
+		crashing.created = nil
+
+		service.Passphrase = nil
+		_, err = service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Error(t, err, "the passphrase of the first attempt is needed")
+
+		service.Passphrase = []byte("other")
+		_, err = service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Error(t, err, "the passphrase of the first attempt is needed")
+		assert.Empty(t, crashing.created, "nothing should be sealed with another key")
+
+		service.Passphrase = []byte("secret")
+		media, err := service.ResumeUpload(service.ctx, "/data.bin")
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.Download(service.ctx, media.ID, "/copy.bin"))
+		got, err := afs.ReadFile("/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("file changed", func(t *testing.T) {
+		service, _ := setup(t, randomBytes(3*uds.ChunkReadLengthBytes))
+
+		assert.NoError(t, afero.WriteFile(AppFs, "/data.bin", randomBytes(10), 0600))
+		_, err := service.ResumeUpload(service.ctx, "/data.bin")
+		assert.EqualError(t, err, "/data.bin changed since its upload started, push it again")
+	})
+
+	t.Run("nothing to resume", func(t *testing.T) {
+		service, _, afs := setupBackend(t)
+		assert.NoError(t, afs.WriteFile("/data.bin", randomBytes(10), 0600))
+
+		_, err := service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Equal(t, ErrNothingToResume, err, "without state directory")
+
+		service.StateDir = "/state"
+		_, err = service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Equal(t, ErrNothingToResume, err)
+
+		_, err = service.Upload(service.ctx, "/data.bin", "")
+		assert.NoError(t, err)
+		_, err = service.ResumeUpload(service.ctx, "/data.bin")
+		assert.Equal(t, ErrNothingToResume, err, "after a complete upload")
+	})
+}
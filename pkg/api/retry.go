@@ -0,0 +1,322 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Clock tells the time and waits for it to pass, so that retries can be
+// tested without actually waiting
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d, or returns the error of ctx once it is done
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryPolicy tells how many times and how long apart a failed call is retried
+type RetryPolicy struct {
+	// MaxAttempts counts the first call as well, 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after every retry
+	Multiplier float64
+	// Jitter is the fraction of every wait that is randomized, from 0 to 1
+	Jitter float64
+}
+
+// DefaultRetryPolicy follows the exponential backoff recommended for Drive
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: time.Second,
+	MaxBackoff:     32 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+// Retry struct configures how Service retries the Drive calls that fail with
+// a retryable error. Its zero value retries with DefaultRetryPolicy.
+type Retry struct {
+	// Policy applies to operations missing from Operations,
+	// DefaultRetryPolicy when zero
+	Policy RetryPolicy
+	// Operations overrides Policy per Backend method, e.g. "CreateDoc"
+	Operations map[string]RetryPolicy
+	// Clock waits between attempts, the wall clock when nil
+	Clock Clock
+}
+
+func (r *Retry) policy(op string) RetryPolicy {
+	if p, ok := r.Operations[op]; ok {
+		return p
+	}
+	if r.Policy == (RetryPolicy{}) {
+		return DefaultRetryPolicy
+	}
+	return r.Policy
+}
+
+func (r *Retry) clock() Clock {
+	if r.Clock == nil {
+		return wallClock{}
+	}
+	return r.Clock
+}
+
+// do calls fn until it succeeds, fails with a fatal error or runs out of
+// attempts. The last error is returned.
+func (r *Retry) do(ctx context.Context, op string, fn func() error) error {
+	policy := r.policy(op)
+	clock := r.clock()
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !Retryable(err) {
+			return err
+		}
+
+		wait, ok := retryAfter(err, clock.Now())
+		if !ok {
+			wait = policy.jitter(backoff)
+		}
+		if err := clock.Sleep(ctx, wait); err != nil {
+			return err
+		}
+
+		backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func (p RetryPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	return d - time.Duration(p.Jitter*rand.Float64()*float64(d))
+}
+
+// Retryable function reports whether a call failing with err may succeed when
+// made again: rate limits, server errors and network timeouts
+func Retryable(err error) bool {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		switch e.Code {
+		case http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		case http.StatusForbidden:
+			for _, item := range e.Errors {
+				if item.Reason == "userRateLimitExceeded" || item.Reason == "rateLimitExceeded" {
+					return true
+				}
+			}
+		}
+		return false
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// retryAfter returns the wait asked by the Retry-After header of err, given
+// either in seconds or as an HTTP date
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	e, ok := err.(*googleapi.Error)
+	if !ok || e.Header == nil {
+		return 0, false
+	}
+	v := e.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// retryBackend makes every call of a Backend go through a Retry
+type retryBackend struct {
+	backend Backend
+	retry   *Retry
+
+	mu  sync.Mutex
+	ids []string
+}
+
+// idBatch is the number of file ids generated at a time for the creates
+const idBatch = 100
+
+func (b *retryBackend) CreateFolder(ctx context.Context, file *drive.File) (*drive.File, error) {
+	return b.create(ctx, "CreateFolder", file, func(file *drive.File) (*drive.File, error) {
+		return b.backend.CreateFolder(ctx, file)
+	})
+}
+
+func (b *retryBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	return b.create(ctx, "CreateDoc", file, func(file *drive.File) (*drive.File, error) {
+		return b.backend.CreateDoc(ctx, file, content)
+	})
+}
+
+// create makes file with fn. Drive may have made the file already when a
+// create fails, so the file is given a generated id first: a retry failing
+// with 409 finds the file made by the previous attempt instead of making it
+// twice.
+func (b *retryBackend) create(
+	ctx context.Context, op string, file *drive.File, fn func(*drive.File) (*drive.File, error),
+) (f *drive.File, err error) {
+	if file.Id == "" && b.retry.policy(op).MaxAttempts > 1 {
+		id, err := b.nextID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c := *file
+		c.Id = id
+		file = &c
+	}
+
+	attempt := 0
+	err = b.retry.do(ctx, op, func() error {
+		attempt++
+		f, err = fn(file)
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusConflict && attempt > 1 && file.Id != "" {
+			f, err = b.backend.Get(ctx, file.Id)
+		}
+		return err
+	})
+	return f, err
+}
+
+// nextID returns an id for a file to create, generating idBatch of them when
+// none are left
+func (b *retryBackend) nextID(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.ids) == 0 {
+		ids, err := b.GenerateIDs(ctx, idBatch)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			return "", errors.New("no file ids generated")
+		}
+		b.ids = ids
+	}
+	id := b.ids[0]
+	b.ids = b.ids[1:]
+	return id, nil
+}
+
+func (b *retryBackend) List(ctx context.Context, q, pageToken string) (l *drive.FileList, err error) {
+	err = b.retry.do(ctx, "List", func() error {
+		l, err = b.backend.List(ctx, q, pageToken)
+		return err
+	})
+	return l, err
+}
+
+func (b *retryBackend) Get(ctx context.Context, id string) (f *drive.File, err error) {
+	err = b.retry.do(ctx, "Get", func() error {
+		f, err = b.backend.Get(ctx, id)
+		return err
+	})
+	return f, err
+}
+
+func (b *retryBackend) Export(ctx context.Context, id string) (content string, err error) {
+	err = b.retry.do(ctx, "Export", func() error {
+		content, err = b.backend.Export(ctx, id)
+		return err
+	})
+	return content, err
+}
+
+func (b *retryBackend) Delete(ctx context.Context, id string) error {
+	return b.retry.do(ctx, "Delete", func() error {
+		return b.backend.Delete(ctx, id)
+	})
+}
+
+func (b *retryBackend) UpdateProperties(ctx context.Context, id string, properties map[string]string) (f *drive.File, err error) {
+	err = b.retry.do(ctx, "UpdateProperties", func() error {
+		f, err = b.backend.UpdateProperties(ctx, id, properties)
+		return err
+	})
+	return f, err
+}
+
+func (b *retryBackend) SetTrashed(ctx context.Context, id string, trashed bool) (f *drive.File, err error) {
+	err = b.retry.do(ctx, "SetTrashed", func() error {
+		f, err = b.backend.SetTrashed(ctx, id, trashed)
+		return err
+	})
+	return f, err
+}
+
+func (b *retryBackend) Move(ctx context.Context, id string, from []string, to string) (f *drive.File, err error) {
+	err = b.retry.do(ctx, "Move", func() error {
+		f, err = b.backend.Move(ctx, id, from, to)
+		return err
+	})
+	return f, err
+}
+
+func (b *retryBackend) About(ctx context.Context) (a *drive.About, err error) {
+	err = b.retry.do(ctx, "About", func() error {
+		a, err = b.backend.About(ctx)
+		return err
+	})
+	return a, err
+}
+
+func (b *retryBackend) GenerateIDs(ctx context.Context, count int) (ids []string, err error) {
+	err = b.retry.do(ctx, "GenerateIDs", func() error {
+		ids, err = b.backend.GenerateIDs(ctx, count)
+		return err
+	})
+	return ids, err
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// fakeClock records the waits instead of sleeping
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// total returns the time spent waiting
+func (c *fakeClock) total() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total time.Duration
+	for _, d := range c.waits {
+		total += d
+	}
+	return total
+}
+
+// flakyBackend fails the first calls of Get with the queued errors
+type flakyBackend struct {
+	Backend
+	errs  []error
+	calls int
+}
+
+func (b *flakyBackend) Get(ctx context.Context, id string) (*drive.File, error) {
+	b.calls++
+	if len(b.errs) > 0 {
+		err := b.errs[0]
+		b.errs = b.errs[1:]
+		return nil, err
+	}
+	return &drive.File{Id: id}, nil
+}
+
+// lossyBackend makes the files it is asked to, but answers the first create
+// of every kind with a server error as if the answer was lost
+type lossyBackend struct {
+	*drivetest.Backend
+	lost map[string]bool
+}
+
+func (b *lossyBackend) fail(op string) error {
+	if b.lost[op] {
+		return nil
+	}
+	b.lost[op] = true
+	return &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "backend error"}
+}
+
+func (b *lossyBackend) CreateFolder(ctx context.Context, file *drive.File) (*drive.File, error) {
+	f, err := b.Backend.CreateFolder(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	return f, b.fail("CreateFolder")
+}
+
+func (b *lossyBackend) CreateDoc(ctx context.Context, file *drive.File, content string) (*drive.File, error) {
+	f, err := b.Backend.CreateDoc(ctx, file, content)
+	if err != nil {
+		return nil, err
+	}
+	return f, b.fail("CreateDoc")
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	noJitter := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2}
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+
+	setup := func(errs ...error) (*Service, *flakyBackend, *fakeClock) {
+		flaky := &flakyBackend{errs: errs}
+		service := NewServiceWithBackend(flaky)
+		clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+		service.Retry = Retry{Policy: noJitter, Clock: clock}
+		return service, flaky, clock
+	}
+
+	t.Run("exponential backoff", func(t *testing.T) {
+		service, flaky, clock := setup(unavailable, unavailable, unavailable)
+
+		f, err := service.backend.Get(ctx, "id-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "id-1", f.Id)
+		assert.Equal(t, 4, flaky.calls)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, clock.waits)
+	})
+
+	t.Run("give up after max attempts", func(t *testing.T) {
+		service, flaky, clock := setup(unavailable, unavailable, unavailable, unavailable, unavailable)
+
+		_, err := service.backend.Get(ctx, "id-1")
+		assert.Equal(t, unavailable, err)
+		assert.Equal(t, 4, flaky.calls)
+		assert.Len(t, clock.waits, 3)
+	})
+
+	t.Run("fatal errors", func(t *testing.T) {
+		for _, err := range []error{
+			&googleapi.Error{Code: http.StatusNotFound},
+			&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "insufficientPermissions"}}},
+			errors.New("boom"),
+		} {
+			service, flaky, clock := setup(err)
+
+			_, got := service.backend.Get(ctx, "id-1")
+			assert.Equal(t, err, got)
+			assert.Equal(t, 1, flaky.calls)
+			assert.Empty(t, clock.waits)
+		}
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		seconds := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}}
+		date := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{
+			"Retry-After": {"Thu, 02 Jan 2020 03:04:25 GMT"},
+		}}
+		service, _, clock := setup(seconds, date)
+
+		_, err := service.backend.Get(ctx, "id-1")
+		assert.NoError(t, err)
+		// the clock moved 7s ahead before the second failure
+		assert.Equal(t, []time.Duration{7 * time.Second, 13 * time.Second}, clock.waits)
+	})
+
+	t.Run("per operation policy", func(t *testing.T) {
+		service, flaky, _ := setup(unavailable)
+		service.Retry.Operations = map[string]RetryPolicy{"Get": {MaxAttempts: 1}}
+
+		_, err := service.backend.Get(ctx, "id-1")
+		assert.Equal(t, unavailable, err)
+		assert.Equal(t, 1, flaky.calls)
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		service, flaky, _ := setup(unavailable, unavailable)
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := service.backend.Get(ctx, "id-1")
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, flaky.calls)
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		policy := RetryPolicy{Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			d := policy.jitter(time.Second)
+			assert.True(t, d > 500*time.Millisecond && d <= time.Second, d)
+		}
+	})
+
+	t.Run("through the drive client", func(t *testing.T) {
+		srv := drivetest.NewServer(afero.NewMemMapFs())
+		defer srv.Close()
+
+		// the first two requests are turned away by a busy frontend
+		failures := 2
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				w.Header().Set("Retry-After", "3")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			srv.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer proxy.Close()
+
+		service, err := NewServiceWithOptions(ctx, option.WithEndpoint(proxy.URL+"/"), option.WithHTTPClient(proxy.Client()))
+		assert.NoError(t, err)
+		clock := &fakeClock{}
+		service.Retry.Clock = clock
+
+		about, err := service.About(ctx)
+		assert.NoError(t, err)
+		assert.NotNil(t, about.User)
+		assert.Equal(t, []time.Duration{3 * time.Second, 3 * time.Second}, clock.waits)
+	})
+
+	t.Run("creates are not made twice", func(t *testing.T) {
+		fsBackup := AppFs
+		AppFs = afero.NewMemMapFs()
+		t.Cleanup(func() {
+			AppFs = fsBackup
+		})
+		afs := &afero.Afero{Fs: AppFs}
+
+		backend := drivetest.NewBackend(afero.NewMemMapFs())
+		service := NewServiceWithBackend(&lossyBackend{Backend: backend, lost: make(map[string]bool)})
+		service.Retry.Clock = &fakeClock{}
+
+		data := randomBytes(uds.ChunkReadLengthBytes + 1)
+		assert.NoError(t, afs.WriteFile("/file.bin", data, 0600))
+		media, err := service.Upload(ctx, "/file.bin", "")
+		assert.NoError(t, err)
+
+		_, err = service.GetBaseFolder()
+		assert.NoError(t, err, "a single root should be made")
+		r, err := backend.List(ctx, NewQuery().Parent(media.ID).String(), "")
+		assert.NoError(t, err)
+		assert.Len(t, r.Files, 2, "a single Doc should be made per part")
+
+		assert.NoError(t, service.Download(ctx, media.ID, "/copy.bin"))
+		got, err := afs.ReadFile("/copy.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("default policy", func(t *testing.T) {
+		r := &Retry{}
+		assert.Equal(t, DefaultRetryPolicy, r.policy("List"))
+		assert.Equal(t, wallClock{}, r.clock())
+	})
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{&googleapi.Error{Code: http.StatusBadGateway}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusGatewayTimeout}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{&googleapi.Error{Code: http.StatusBadRequest}, false},
+		{&googleapi.Error{Code: http.StatusUnauthorized}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{timeoutError{}, true},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+		{errors.New("boom"), false},
+	} {
+		assert.Equal(t, tc.want, Retryable(tc.err), tc.err)
+	}
+}
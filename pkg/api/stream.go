@@ -0,0 +1,267 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+var errClosed = errors.New("uds: file already closed")
+
+// Create returns a writer storing everything written to it as a new UDS file
+// called name under the UDS root. Data is cut on the same chunk boundaries as
+// Upload, so no more than one chunk is held in memory at a time. The size and
+// checksums of the file are recorded on its media folder when the writer is closed.
+func (api *Service) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	parentID, err := api.resolveParent("")
+	if err != nil {
+		return nil, err
+	}
+
+	media := uds.NewFile(name, mimeTypeOf(name), 0, "", []string{parentID})
+	meta, err := media.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	t, err := api.newTransform(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := api.createMediaFolder(media, meta)
+	if err != nil {
+		return nil, err
+	}
+	media.ID = folder.Id
+
+	return &writer{
+		api:       api,
+		ctx:       ctx,
+		media:     media,
+		meta:      meta,
+		transform: t,
+		buf:       make([]byte, 0, t.codec.ChunkSize()),
+		hash:      md5.New(),
+		sha256:    sha256.New(),
+	}, nil
+}
+
+type writer struct {
+	api       *Service
+	ctx       context.Context
+	media     *uds.File
+	meta      *uds.Metadata
+	transform *transform
+	buf       []byte
+	part      int64
+	size      int64
+	encoded   int64
+	hash      hash.Hash
+	sha256    hash.Hash
+
+	err    error
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		n += k
+
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.flush(); w.err != nil {
+				return n, w.err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *writer) flush() error {
+	chunk := w.transform.chunk(w.media, w.part, w.size+int64(len(w.buf)))
+
+	encoded, err := w.api.uploadChunk(w.ctx, chunk, w.buf)
+	if err != nil {
+		return err
+	}
+	w.encoded += encoded
+	_, _ = w.hash.Write(w.buf)
+	_, _ = w.sha256.Write(w.buf)
+	w.size += int64(len(w.buf))
+	w.part++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return errClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.buf = nil
+
+	w.meta.Size = w.size
+	w.meta.MD5 = hex.EncodeToString(w.hash.Sum(nil))
+	w.meta.SHA256 = hex.EncodeToString(w.sha256.Sum(nil))
+	w.meta.EncodedSize = w.encoded
+	_, err := w.api.backend.UpdateProperties(w.ctx, w.media.ID, w.meta.Properties())
+	return err
+}
+
+// Open returns a reader streaming the content of the UDS file id. Once reading
+// starts, up to Workers chunk Docs are fetched ahead of the reader and handed
+// out in order. The MD5 of the whole file is checked once the end is reached.
+func (api *Service) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	folder, meta, err := api.getMedia(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := api.openTransform(folder, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := api.listChunks(ctx, id, t.numChunks(meta.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &reader{
+		api:       api,
+		ctx:       ctx,
+		cancel:    cancel,
+		media:     &uds.File{Name: folder.Name, ID: folder.Id, MD5: meta.MD5},
+		transform: t,
+		size:      meta.Size,
+		docs:      docs,
+		hash:      md5.New(),
+	}, nil
+}
+
+type reader struct {
+	api       *Service
+	ctx       context.Context
+	cancel    context.CancelFunc
+	media     *uds.File
+	transform *transform
+	size      int64
+	docs      []*drive.File
+	futures   <-chan chan fetched
+	part      int64
+	buf       []byte
+	hash      hash.Hash
+
+	once     sync.Once
+	fetchErr error
+
+	err    error
+	closed bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	for len(r.buf) == 0 {
+		if r.part == int64(len(r.docs)) {
+			if got := hex.EncodeToString(r.hash.Sum(nil)); got != r.media.MD5 {
+				return 0, fmt.Errorf("md5 mismatch of %s: got %s, want %s", r.media.ID, got, r.media.MD5)
+			}
+			return 0, io.EOF
+		}
+
+		b, err := r.next()
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		_, _ = r.hash.Write(b)
+		r.buf = b
+		r.part++
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next returns the bytes of the current part once they are fetched
+func (r *reader) next() ([]byte, error) {
+	if r.futures == nil {
+		r.futures = prefetch(r.ctx, r.part, int64(len(r.docs)), r.api.workers(), r.fetch)
+	}
+
+	future, ok := <-r.futures
+	if !ok {
+		return nil, r.failed(r.ctx.Err())
+	}
+	res := <-future
+	if res.err != nil {
+		return nil, r.failed(res.err)
+	}
+	return res.b, nil
+}
+
+func (r *reader) fetch(part int64) ([]byte, error) {
+	chunk := r.transform.chunk(r.media, part, r.size)
+	b, err := r.api.downloadChunk(r.ctx, chunk, r.docs[part])
+	if err != nil {
+		return nil, r.failed(err)
+	}
+	return b, nil
+}
+
+// failed records the first error of the reader and cancels the fetches still
+// running, which then fail with a context error. That first error is returned.
+func (r *reader) failed(err error) error {
+	r.once.Do(func() {
+		r.fetchErr = err
+		r.cancel()
+	})
+	return r.fetchErr
+}
+
+func (r *reader) Close() error {
+	if r.closed {
+		return errClosed
+	}
+	r.closed = true
+	r.buf = nil
+	r.cancel()
+	return nil
+}
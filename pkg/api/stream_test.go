@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestStream(t *testing.T) {
+	t.Run("write then read", func(t *testing.T) {
+		service, backend, _ := setupBackend(t)
+
+		data := randomBytes(2*uds.ChunkReadLengthBytes + 99)
+
+		w, err := service.Create(service.ctx, "dump.sql")
+		assert.NoError(t, err)
+
+		// odd sized writes cross the chunk boundaries
+		n, err := io.CopyBuffer(w, bytes.NewReader(data), make([]byte, 100003))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+		assert.NoError(t, w.Close())
+
+		_, err = w.Write([]byte("late"))
+		assert.Error(t, err)
+		assert.Error(t, w.Close())
+
+		id := w.(*writer).media.ID
+		folder, err := backend.Get(service.ctx, id)
+		assert.NoError(t, err)
+
+		sum := md5.Sum(data)
+		assert.Equal(t, hex.EncodeToString(sum[:]), folder.Properties["md5"])
+		assert.Equal(t, "1500099", folder.Properties["size_numeric"])
+
+		r, err := service.Open(service.ctx, id)
+		assert.NoError(t, err)
+
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		assert.NoError(t, r.Close())
+		_, err = r.Read(make([]byte, 1))
+		assert.Error(t, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		service, _, _ := setupBackend(t)
+
+		w, err := service.Create(service.ctx, "empty")
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		r, err := service.Open(service.ctx, w.(*writer).media.ID)
+		assert.NoError(t, err)
+
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		service, backend, _ := setupBackend(t)
+		service.Passphrase = []byte("secret")
+
+		data := randomBytes(uds.ChunkReadLengthBytes + 99)
+
+		w, err := service.Create(service.ctx, "secret.txt")
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		id := w.(*writer).media.ID
+		folder, err := backend.Get(service.ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, uds.CipherAES256GCM, folder.Properties["cipher"])
+		assert.Equal(t, "750099", folder.Properties["size_numeric"])
+
+		r, err := service.Open(service.ctx, id)
+		assert.NoError(t, err)
+		got, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		reader, err := service.NewReader(service.ctx, id)
+		assert.NoError(t, err)
+		tail := make([]byte, 10)
+		_, err = reader.ReadAt(tail, int64(len(data)-10))
+		assert.NoError(t, err)
+		assert.Equal(t, data[len(data)-10:], tail)
+
+		service.Passphrase = nil
+		_, err = service.Open(service.ctx, id)
+		assert.Error(t, err)
+		_, err = service.NewReader(service.ctx, id)
+		assert.Error(t, err)
+	})
+
+	t.Run("read detects md5 mismatch", func(t *testing.T) {
+		service, backend, _ := setupBackend(t)
+
+		w, err := service.Create(service.ctx, "data")
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("some data"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		id := w.(*writer).media.ID
+		_, err = backend.UpdateProperties(service.ctx, id, map[string]string{"md5": "tampered"})
+		assert.NoError(t, err)
+
+		r, err := service.Open(service.ctx, id)
+		assert.NoError(t, err)
+
+		_, err = ioutil.ReadAll(r)
+		assert.Error(t, err)
+	})
+}
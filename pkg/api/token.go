@@ -2,49 +2,124 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/spf13/afero"
-	context2 "golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/drive/v3"
 
 	"github.com/zrma/uds-go/pkg/api/browser"
 )
 
 type helper struct {
-	ConfigFromJSON   func(jsonKey []byte, scope ...string) (*oauth2.Config, error)
-	GetTokenFromFile func(filePath string) (*oauth2.Token, error)
-	GetTokenFromWeb  func(config *oauth2.Config) (*oauth2.Token, error)
-	ScanAuthCode     func() (string, error)
-	ExchangeToken    func(config *oauth2.Config, token *oauth2.Token) (*oauth2.Token, error)
-	OpenBrowser      func(url string) error
-	GetToken         func(config *oauth2.Config, fileName string) (*oauth2.Token, error)
+	ConfigFromJSON     func(jsonKey []byte, scope ...string) (*oauth2.Config, error)
+	JWTConfigFromJSON  func(jsonKey []byte, scope ...string) (*jwt.Config, error)
+	GetTokenFromFile   func(filePath string) (*oauth2.Token, error)
+	GetTokenFromWeb    func(config *oauth2.Config) (*oauth2.Token, error)
+	GetTokenFromDevice func(config *oauth2.Config) (*oauth2.Token, error)
+	ExchangeToken      func(config *oauth2.Config, token *oauth2.Token) (*oauth2.Token, error)
+	OpenBrowser        func(url string) error
+	GetToken           func(config *oauth2.Config, fileName string) (*oauth2.Token, error)
+
+	// DeviceAuthURL is the device authorization endpoint of GetTokenFromDevice
+	DeviceAuthURL string
+	// Clock paces the polls of GetTokenFromDevice, the wall clock when nil
+	Clock Clock
+	// CallbackPort is the loopback port the redirect of the browser sign in
+	// is served on, a free one when 0. A fixed port can be let through a
+	// firewall or forwarded over SSH.
+	CallbackPort int
+	// CallbackTimeout is how long the browser sign in is waited for
+	CallbackTimeout time.Duration
 }
 
 var Helper helper
 
+// DefaultCallbackTimeout is how long the browser sign in is waited for when
+// Helper.CallbackTimeout is not set
+const DefaultCallbackTimeout = 5 * time.Minute
+
 func init() {
 	Helper = helper{
-		ConfigFromJSON:   google.ConfigFromJSON,
-		GetTokenFromFile: getTokenFromFile,
-		GetTokenFromWeb:  getTokenFromWeb,
-		ScanAuthCode: func() (s string, e error) {
-			_, e = fmt.Scan(&s)
-			return
-		},
-		OpenBrowser: browser.Open,
-		GetToken:    getToken,
+		ConfigFromJSON:     google.ConfigFromJSON,
+		JWTConfigFromJSON:  google.JWTConfigFromJSON,
+		GetTokenFromFile:   getTokenFromFile,
+		GetTokenFromWeb:    getTokenFromWeb,
+		GetTokenFromDevice: getTokenFromDevice,
+		OpenBrowser:        browser.Open,
+		GetToken:           getToken,
+		DeviceAuthURL:      GoogleDeviceAuthURL,
+		CallbackTimeout:    DefaultCallbackTimeout,
+	}
+}
+
+// serviceAccountType is the type of the JSON keys of service accounts
+const serviceAccountType = "service_account"
+
+// tokenSource returns the tokens authorizing the Drive calls made with the
+// credentials in jsonKey. Service account keys, told apart by their type
+// field, sign their own tokens and need nobody at a browser. OAuth clients go
+// through the installed app flow, and keep their token where tokenLocation
+// tells, unless it was given inline.
+func (api *Service) tokenSource(ctx context.Context, jsonKey []byte) (oauth2.TokenSource, error) {
+	var key struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(jsonKey, &key); err != nil {
+		return nil, err
+	}
+
+	if key.Type == serviceAccountType {
+		config, err := Helper.JWTConfigFromJSON(jsonKey, drive.DriveScope)
+		if err != nil {
+			return nil, err
+		}
+		config.Subject = api.Subject
+		return config.TokenSource(ctx), nil
+	}
+
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := Helper.ConfigFromJSON(jsonKey, drive.DriveScope)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenPath, inline, err := api.tokenLocation()
+	if err != nil {
+		return nil, err
+	}
+	if inline != nil {
+		token := &oauth2.Token{}
+		if err := json.Unmarshal(inline, token); err != nil {
+			return nil, fmt.Errorf("%s: %v", TokenEnv, err)
+		}
+		return config.TokenSource(ctx, token), nil
+	}
+
+	token, err := Helper.GetToken(config, tokenPath)
+	if err != nil {
+		return nil, err
 	}
+	return config.TokenSource(ctx, token), nil
 }
 
 func getToken(config *oauth2.Config, fileName string) (*oauth2.Token, error) {
@@ -82,105 +157,259 @@ const (
 	tokenFile      = "token.json"
 )
 
-func GetTokenWithBrowser(ln net.Listener) (string, error) {
-	tokenCh := make(chan string)
-	defer close(tokenCh)
+// callback is what the authorization server redirected the browser with
+type callback struct {
+	code string
+	err  error
+}
 
-	var do sync.Once
+var (
+	// errAccessDenied is returned when the user refuses the access to Drive
+	errAccessDenied = errors.New("access to Google Drive was denied")
+	// errCallbackState is a callback of another flow, or a forged one
+	errCallbackState = errors.New("invalid callback state, the sign in was not started by this UDS")
+)
 
-	handler := http.NewServeMux()
-	handler.HandleFunc("/auth/callback/", func(w http.ResponseWriter, r *http.Request) {
-		const response = `
+const callbackPage = `<!DOCTYPE html>
+<html>
+<head><title>UDS</title></head>
 <body>
-Finished
+<h1>%s</h1>
+<p>%s</p>
 </body>
+</html>
 `
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(response))
+
+// GetTokenWithBrowser serves the redirect of the authorization server on ln
+// and returns the authorization code it carries. The redirect must carry the
+// state the flow was started with: callbacks of other flows are turned away
+// while the right one is waited for.
+// It gives up when ctx is done, and listens on a free loopback port when ln
+// is nil.
+func GetTokenWithBrowser(ctx context.Context, ln net.Listener, state string) (string, error) {
+	tokenCh := make(chan callback, 1)
+
+	var do sync.Once
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/auth/callback/", func(w http.ResponseWriter, r *http.Request) {
+		res := readCallback(r.URL.Query(), state)
+
+		status, title, message := http.StatusOK, "Finished", "You can close this window and go back to UDS."
+		switch {
+		case res.err == errAccessDenied:
+			status, title = http.StatusForbidden, "Access denied"
+			message = "UDS was not allowed to access Google Drive. Run it again to grant the access."
+		case res.err != nil:
+			status, title, message = http.StatusBadRequest, "Sign in failed", res.err.Error()
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, callbackPage, title, html.EscapeString(message))
+
+		if res.err == errCallbackState {
+			// anything may reach the port, the sign in goes on
+			return
+		}
 		do.Do(func() {
-			tokenCh <- r.URL.RawQuery
+			tokenCh <- res
 		})
 	})
 
 	if ln == nil {
 		var err error
-		ln, err = net.Listen("tcp", ":0")
+		ln, err = listenLoopback(0)
 		if err != nil {
 			return "", err
 		}
 	}
 
-	addr := strings.Split(ln.Addr().String(), "]")[1]
 	srv := &http.Server{
-		Addr:    addr,
+		Addr:    ln.Addr().String(),
 		Handler: handler,
 	}
 	go func() {
-		if err := srv.Serve(ln); err != nil {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Println("callback listen server closed", err)
 		}
 	}()
 
-	rawQuery := <-tokenCh
-	m, err := url.ParseQuery(rawQuery)
+	var res callback
+	select {
+	case res = <-tokenCh:
+	case <-ctx.Done():
+		res.err = fmt.Errorf("waiting for the browser sign in: %v", ctx.Err())
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), callbackShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("callback listen server shutdown", err)
+	}
+	return res.code, res.err
+}
+
+// callbackShutdownTimeout bounds the wait for the callback page to be written
+// once the sign in is over
+const callbackShutdownTimeout = 5 * time.Second
+
+// listenLoopback listens on port of the IPv4 loopback address, or of the IPv6
+// one on hosts without IPv4, so that the callback is not reachable from the
+// network. A free port is picked when port is 0.
+func listenLoopback(port int) (net.Listener, error) {
+	p := strconv.Itoa(port)
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", p))
+	if err == nil || hasIPv4Loopback() {
+		return ln, err
+	}
+	return net.Listen("tcp", net.JoinHostPort("::1", p))
+}
+
+// hasIPv4Loopback reports whether the IPv4 loopback address can be listened on
+func hasIPv4Loopback() bool {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return false
+	}
+	_ = ln.Close()
+	return true
+}
+
+// callbackURL returns the redirect URI of the callback served on ln
+func callbackURL(ln net.Listener) (string, error) {
+	host, port, err := net.SplitHostPort(ln.Addr().String())
 	if err != nil {
 		return "", err
 	}
+	return fmt.Sprintf("http://%s/auth/callback/", net.JoinHostPort(host, port)), nil
+}
 
-	authCodes, ok := m["code"]
-	if !ok || len(authCodes) == 0 {
-		return "", errors.New(fmt.Sprintln("invalid callback params", rawQuery))
+// withInterrupt returns a copy of ctx that is canceled on Ctrl-C as well
+func withInterrupt(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
 	}
+}
 
-	if err := srv.Shutdown(context2.Background()); err != nil {
-		log.Println("callback listen server shutdown", err)
+// readCallback returns the authorization code in the query of a redirect,
+// or the error it reports
+func readCallback(query url.Values, state string) callback {
+	if got := query.Get("state"); subtle.ConstantTimeCompare([]byte(got), []byte(state)) != 1 {
+		return callback{err: errCallbackState}
 	}
-	return authCodes[0], nil
+	switch e := query.Get("error"); e {
+	case "":
+	case "access_denied":
+		return callback{err: errAccessDenied}
+	default:
+		return callback{err: fmt.Errorf("authorization failed: %s", e)}
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return callback{err: errors.New(fmt.Sprintln("invalid callback params", query.Encode()))}
+	}
+	return callback{code: code}
 }
 
-func getAuthCodeOffline(config *oauth2.Config) (string, error) {
-	config.RedirectURL = "urn:ietf:wg:oauth:2.0:oob"
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the authorization code: \n%v\n\n>>", authURL)
-	return Helper.ScanAuthCode()
+// randomToken returns a random URL safe string of n random bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func getAuthCode(config *oauth2.Config) (authCode string, err error) {
-	var ln net.Listener
-	ln, err = net.Listen("tcp", ":0")
+// newPKCE returns a PKCE code verifier along with its S256 challenge
+func newPKCE() (verifier, challenge string, err error) {
+	if verifier, err = randomToken(32); err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// errNoBrowser tells no browser could be opened for the authorization
+var errNoBrowser = errors.New("no browser to authorize with")
+
+// getAuthCode has the user authorize the access in a browser, with the PKCE
+// challenge of the flow, and returns the authorization code
+func getAuthCode(ctx context.Context, config *oauth2.Config, challenge string) (string, error) {
+	if Helper.OpenBrowser == nil {
+		return "", errNoBrowser
+	}
+	state, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	ln, err := listenLoopback(Helper.CallbackPort)
 	if err != nil {
 		return "", err
 	}
 	defer func() {
-		err = ln.Close()
+		_ = ln.Close()
 	}()
 
-	addr := strings.Split(ln.Addr().String(), "]")[1]
-	config.RedirectURL = fmt.Sprintf("http://localhost%s/auth/callback/", addr)
-	if Helper.OpenBrowser == nil {
-		return getAuthCodeOffline(config)
+	if config.RedirectURL, err = callbackURL(ln); err != nil {
+		return "", err
 	}
 
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
 	if err := Helper.OpenBrowser(authURL); err != nil {
-		return getAuthCodeOffline(config)
+		return "", errNoBrowser
 	}
+	return GetTokenWithBrowser(ctx, ln, state)
+}
 
-	if authCode, err = GetTokenWithBrowser(ln); err != nil {
-		return getAuthCodeOffline(config)
+// getTokenFromWeb asks the user to authorize the access to Drive in a browser
+// and gets the token through the redirect to a local callback server, which
+// is given up after Helper.CallbackTimeout or on Ctrl-C. On machines without
+// a browser, like over SSH, the device flow is used instead, bound by the
+// expiry of its code only.
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	verifier, challenge, err := newPKCE()
+	if err != nil {
+		return nil, err
 	}
 
-	return authCode, nil
-}
+	timeout := Helper.CallbackTimeout
+	if timeout <= 0 {
+		timeout = DefaultCallbackTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx, stop := withInterrupt(ctx)
+	defer stop()
 
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authCode, err := getAuthCode(config)
+	authCode, err := getAuthCode(ctx, config, challenge)
+	if err == errNoBrowser {
+		// the device flow waits far longer than the callback, and Ctrl-C must
+		// stop it as usual
+		stop()
+		cancel()
+		return Helper.GetTokenFromDevice(config)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println(authCode)
-	token, err := config.Exchange(context2.Background(), authCode)
+	token, err := config.Exchange(ctx, authCode, oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
 		return nil, err
 	}
@@ -203,6 +432,9 @@ func getTokenFromFile(file string) (token *oauth2.Token, err error) {
 
 func saveToken(path string, token *oauth2.Token) (err error) {
 	fmt.Printf("Saving credential file to: %s\n", path)
+	if err := AppFs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
 	var f afero.File
 	f, err = AppFs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
@@ -1,10 +1,22 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,8 +48,8 @@ func TestGetToken(t *testing.T) {
 	}
 
 	t.Run("GetToken should fail", func(t *testing.T) {
-		Helper.ScanAuthCode = func() (string, error) {
-			return "", errors.New("error-1234")
+		Helper.GetTokenFromDevice = func(*oauth2.Config) (*oauth2.Token, error) {
+			return nil, errors.New("error-1234")
 		}
 
 		got, err := Helper.GetToken(&config, "")
@@ -47,9 +59,28 @@ func TestGetToken(t *testing.T) {
 	})
 
 	t.Run("getTokenFromWeb", func(t *testing.T) {
-		t.Run("reading string failed", func(t *testing.T) {
-			Helper.ScanAuthCode = func() (string, error) {
-				return "", errors.New("test")
+		t.Run("device flow without browser", func(t *testing.T) {
+			want := &oauth2.Token{AccessToken: "device-1234"}
+			Helper.GetTokenFromDevice = func(*oauth2.Config) (*oauth2.Token, error) {
+				return want, nil
+			}
+
+			got, err := Helper.GetTokenFromWeb(&config)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			Helper.OpenBrowser = func(string) error {
+				return errors.New("xdg-open not found")
+			}
+			got, err = Helper.GetTokenFromWeb(&config)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+
+		t.Run("device flow failed", func(t *testing.T) {
+			Helper.OpenBrowser = nil
+			Helper.GetTokenFromDevice = func(*oauth2.Config) (*oauth2.Token, error) {
+				return nil, errors.New("test")
 			}
 
 			got, err := Helper.GetTokenFromWeb(&config)
@@ -57,10 +88,27 @@ func TestGetToken(t *testing.T) {
 			assert.Nil(t, got)
 		})
 
-		t.Run("exchange failed", func(t *testing.T) {
-			Helper.ScanAuthCode = func() (string, error) {
-				return "token-1234", nil
+		// authorize stands in for the user granting the access in the browser,
+		// calling back the redirect URI with the state of authURL
+		authorize := func(t *testing.T, check func(url.Values)) func(string) error {
+			return func(authURL string) error {
+				u, err := url.Parse(authURL)
+				assert.NoError(t, err)
+				query := u.Query()
+				check(query)
+				go func() {
+					callback := query.Get("redirect_uri") + "?code=code-1234&state=" + url.QueryEscape(query.Get("state"))
+					resp, err := http.Get(callback)
+					if assert.NoError(t, err) {
+						_ = resp.Body.Close()
+					}
+				}()
+				return nil
 			}
+		}
+
+		t.Run("exchange failed", func(t *testing.T) {
+			Helper.OpenBrowser = authorize(t, func(url.Values) {})
 
 			got, err := Helper.GetTokenFromWeb(&oauth2.Config{
 				ClientID:     "client-1",
@@ -76,6 +124,46 @@ func TestGetToken(t *testing.T) {
 			assert.Error(t, err)
 			assert.Nil(t, got)
 		})
+
+		t.Run("state and PKCE", func(t *testing.T) {
+			var states []string
+			var challenge string
+			Helper.OpenBrowser = authorize(t, func(query url.Values) {
+				assert.Equal(t, "S256", query.Get("code_challenge_method"))
+				challenge = query.Get("code_challenge")
+				assert.NotEmpty(t, challenge)
+				assert.NotEmpty(t, query.Get("state"))
+				states = append(states, query.Get("state"))
+			})
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				assert.Equal(t, "code-1234", r.PostForm.Get("code"))
+				sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+				assert.Equal(t, challenge, base64.RawURLEncoding.EncodeToString(sum[:]), "verifier should match the challenge")
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"access_token": "access-1234",
+					"token_type":   "Bearer",
+					"expires_in":   3600,
+				})
+			}))
+			t.Cleanup(srv.Close)
+
+			config := &oauth2.Config{
+				ClientID:     "client-1",
+				ClientSecret: "secret-2",
+				Endpoint:     oauth2.Endpoint{AuthURL: "auth-url-1", TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
+				Scopes:       []string{drive.DriveScope},
+			}
+			for i := 0; i < 2; i++ {
+				got, err := Helper.GetTokenFromWeb(config)
+				assert.NoError(t, err)
+				if assert.NotNil(t, got) {
+					assert.Equal(t, "access-1234", got.AccessToken)
+				}
+			}
+			assert.NotEqual(t, states[0], states[1], "every flow should have its own state")
+		})
 	})
 }
 
@@ -101,9 +189,6 @@ func TestTokenFileIO(t *testing.T) {
 	})
 
 	t.Run("GetToken test after setting files...", func(t *testing.T) {
-		Helper.ScanAuthCode = func() (string, error) {
-			return "", nil
-		}
 		Helper.GetTokenFromWeb = func(config *oauth2.Config) (token *oauth2.Token, err error) {
 			return want, nil
 		}
@@ -126,9 +211,6 @@ func TestTokenFileIO(t *testing.T) {
 			token.Expiry = time.Now().Add(time.Minute)
 			return token, nil
 		}
-		Helper.ScanAuthCode = func() (string, error) {
-			return "", nil
-		}
 		Helper.GetTokenFromWeb = func(config *oauth2.Config) (*oauth2.Token, error) {
 			assert.Equal(t, givenCfg, config)
 			return want, nil
@@ -154,6 +236,64 @@ func TestTokenFileIO(t *testing.T) {
 	})
 }
 
+func TestServiceAccountToken(t *testing.T) {
+	var claims map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+		segments := strings.Split(r.PostForm.Get("assertion"), ".")
+		if assert.Len(t, segments, 3) {
+			b, err := base64.RawURLEncoding.DecodeString(segments[1])
+			assert.NoError(t, err)
+			assert.NoError(t, json.Unmarshal(b, &claims))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "access-1234", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	key := serviceAccountKey(t, srv.URL)
+	for _, subject := range []string{"", "admin@example.com"} {
+		service := &Service{Subject: subject}
+		ts, err := service.tokenSource(context.Background(), key)
+		assert.NoError(t, err)
+
+		token, err := ts.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, "access-1234", token.AccessToken)
+
+		assert.Equal(t, "uds@project.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, drive.DriveScope, claims["scope"])
+		if subject == "" {
+			assert.NotContains(t, claims, "sub")
+		} else {
+			assert.Equal(t, subject, claims["sub"])
+		}
+	}
+}
+
+// serviceAccountKey returns the JSON key of a service account getting its
+// tokens from tokenURI
+func serviceAccountKey(t *testing.T, tokenURI string) []byte {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)})
+
+	key, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project",
+		"private_key_id": "key-1234",
+		"private_key":    string(pemKey),
+		"client_email":   "uds@project.iam.gserviceaccount.com",
+		"client_id":      "1234",
+		"token_uri":      tokenURI,
+	})
+	assert.NoError(t, err)
+	return key
+}
+
 func equalTokens(t *testing.T, given, got *oauth2.Token) {
 	assert.Equal(t, given.TokenType, got.TokenType)
 	assert.Equal(t, given.AccessToken, got.AccessToken)
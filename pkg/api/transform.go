@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// transform is how the raw bytes of one file are turned into chunk Docs and
+// back, as recorded in the properties of its media folder
+type transform struct {
+	codec       uds.Codec
+	compression string
+	cipher      *uds.Cipher
+}
+
+// chunk returns part of media, a file of size bytes
+func (t *transform) chunk(media *uds.File, part, size int64) *uds.Chunk {
+	c := &uds.Chunk{
+		Part:        part,
+		MaxSize:     size,
+		Media:       media,
+		Parent:      media.ID,
+		Codec:       t.codec,
+		Compression: t.compression,
+		Cipher:      t.cipher,
+	}
+	c.Init()
+	return c
+}
+
+// numChunks returns the number of chunks of a file of size bytes
+func (t *transform) numChunks(size int64) int64 {
+	return uds.NumChunks(size, t.codec.ChunkSize())
+}
+
+// newTransform picks the transform of a new file following the settings of
+// the service, and records it in meta
+func (api *Service) newTransform(meta *uds.Metadata) (*transform, error) {
+	codec, err := uds.CodecByName(api.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if codec.Name() != uds.Base64.Name() {
+		// Base64 is left out, so that readers of version 1 read the file
+		meta.Codec = codec.Name()
+	}
+
+	t := &transform{codec: codec}
+	if api.Compression != uds.CompressionNone && uds.Compressible(meta.Mime) {
+		if !uds.ValidCompression(api.Compression) {
+			return nil, fmt.Errorf("unsupported compression %q", api.Compression)
+		}
+		meta.Compression = api.Compression
+		t.compression = api.Compression
+	}
+	if len(api.Passphrase) > 0 {
+		cipher, err := meta.Encrypt(api.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		t.cipher = cipher
+	}
+	return t, nil
+}
+
+// openTransform returns the transform the chunks of the media folder were
+// stored with
+func (api *Service) openTransform(folder *drive.File, meta *uds.Metadata) (*transform, error) {
+	codec, err := uds.CodecByName(meta.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %v", folder.Name, folder.Id, err)
+	}
+	cipher, err := meta.OpenCipher(api.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %v", folder.Name, folder.Id, err)
+	}
+	return &transform{codec: codec, compression: meta.Compression, cipher: cipher}, nil
+}
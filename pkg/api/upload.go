@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+const defaultMimeType = "application/octet-stream"
+
+// Upload splits the local file at path into chunks and stores every chunk as
+// a Doc inside a new media folder. The UDS root is used when parentID is empty.
+// Up to Workers chunks are uploaded at a time, and the others are cancelled as
+// soon as one of them fails. The progress is journaled under StateDir, so that
+// a failed upload can be completed with ResumeUpload.
+func (api *Service) Upload(ctx context.Context, path, parentID string) (*uds.File, error) {
+	f, err := AppFs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	md5sum, sha256sum, err := checksums(f)
+	if err != nil {
+		return nil, err
+	}
+
+	parentID, err = api.resolveParent(parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	media := uds.NewFile(
+		filepath.Base(path),
+		mimeTypeOf(path),
+		size,
+		md5sum,
+		[]string{parentID},
+	)
+	media.SHA256 = sha256sum
+
+	meta, err := media.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	t, err := api.newTransform(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := api.createMediaFolder(media, meta)
+	if err != nil {
+		return nil, err
+	}
+	media.ID = folder.Id
+
+	j, err := api.newJournal(path, media)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.save(); err != nil {
+		return nil, err
+	}
+
+	parts := make([]int64, t.numChunks(size))
+	for i := range parts {
+		parts[i] = int64(i)
+	}
+	if err := api.uploadParts(ctx, f, path, media, size, t, parts, j); err != nil {
+		return nil, err
+	}
+	if err := api.recordEncodedSize(ctx, media, meta, j, int64(len(parts))); err != nil {
+		return nil, err
+	}
+	return media, j.remove()
+}
+
+// uploadParts uploads the given parts of the local file f of size bytes,
+// recording every completed one in j
+func (api *Service) uploadParts(
+	ctx context.Context, f io.ReaderAt, path string, media *uds.File, size int64, t *transform, parts []int64, j *journal,
+) error {
+	// chunks are read one at a time, as not every afero.File supports
+	// concurrent ReadAt calls, but uploaded by up to api.Workers at once
+	var mu sync.Mutex
+	return runParallel(ctx, int64(len(parts)), api.workers(), func(ctx context.Context, i int64) error {
+		chunk := t.chunk(media, parts[i], size)
+		chunk.Path = path
+
+		mu.Lock()
+		b, err := chunk.Read(f)
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		encoded, err := api.uploadChunk(ctx, chunk, b)
+		if err != nil {
+			return err
+		}
+		return j.complete(chunk.Part, encoded)
+	})
+}
+
+// recordEncodedSize records on the media folder the size of the count chunk
+// Docs of a completed upload, as journaled. It is left unknown when a part
+// was uploaded without being journaled, by a process that died in between.
+func (api *Service) recordEncodedSize(
+	ctx context.Context, media *uds.File, meta *uds.Metadata, j *journal, count int64,
+) error {
+	if int64(len(j.Encoded)) != count {
+		return nil
+	}
+	meta.EncodedSize = 0
+	for _, encoded := range j.Encoded {
+		meta.EncodedSize += encoded
+	}
+	media.EncodedSize = uds.FormatSize(meta.EncodedSize)
+	_, err := api.backend.UpdateProperties(ctx, media.ID, meta.Properties())
+	return err
+}
+
+// checksums returns the hex MD5 and SHA-256 of everything read from r
+func checksums(r io.Reader) (md5sum, sha256sum string, err error) {
+	m, s := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(m, s), r); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(m.Sum(nil)), hex.EncodeToString(s.Sum(nil)), nil
+}
+
+// resolveParent returns parentID, or the UDS root folder when it is empty
+func (api *Service) resolveParent(parentID string) (string, error) {
+	if parentID != "" {
+		return parentID, nil
+	}
+	root, err := api.GetBaseFolder()
+	if err != nil {
+		return "", err
+	}
+	return root.Id, nil
+}
+
+// uploadChunk stores the raw bytes b of chunk as a Doc, and returns the size
+// of its text
+func (api *Service) uploadChunk(ctx context.Context, chunk *uds.Chunk, b []byte) (int64, error) {
+	content, err := chunk.Encode(b)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = api.backend.CreateDoc(ctx, &drive.File{
+		Name:       chunk.Name(),
+		MimeType:   docMimeType,
+		Parents:    []string{chunk.Parent},
+		Properties: chunk.Properties(),
+	}, content)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(content)), nil
+}
+
+func mimeTypeOf(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return defaultMimeType
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestUpload(t *testing.T) {
+	t.Run("split into chunk docs", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+
+		data := randomBytes(2*uds.ChunkReadLengthBytes + 123)
+		assert.NoError(t, afs.WriteFile("/data/dump.bin", data, 0600))
+
+		media, err := service.Upload(service.ctx, "/data/dump.bin", "")
+		assert.NoError(t, err)
+
+		sum := md5.Sum(data)
+		assert.Equal(t, "dump.bin", media.Name)
+		assert.Equal(t, "application/octet-stream", media.Mime)
+		assert.Equal(t, "1500123", media.SizeNumeric)
+		assert.Equal(t, hex.EncodeToString(sum[:]), media.MD5)
+
+		folder, err := backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, folderMimeType, folder.MimeType)
+		assert.Equal(t, media.Parents, folder.Parents)
+		assert.Equal(t, "true", folder.Properties["uds"])
+		assert.Equal(t, media.MD5, folder.Properties["md5"])
+		assert.Equal(t, media.SizeNumeric, folder.Properties["size_numeric"])
+		assert.Equal(t, "1", folder.Properties["uds_version"], "default settings should need no newer reader")
+		assert.NotContains(t, folder.Properties, "codec")
+
+		root, err := service.GetBaseFolder()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{root.Id}, media.Parents)
+
+		r, err := backend.List(service.ctx, "'"+media.ID+"' in parents", "")
+		assert.NoError(t, err)
+		// chunks are uploaded concurrently, so in no particular order
+		names := map[string]string{}
+		for _, f := range r.Files {
+			assert.Equal(t, docMimeType, f.MimeType)
+			names[f.Properties["part"]] = f.Name
+		}
+		assert.Equal(t, map[string]string{"0": "dump.bin0", "1": "dump.bin1", "2": "dump.bin2"}, names)
+	})
+
+	t.Run("record the encoded size", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+		service.Codec = "base16384"
+		service.Compression = uds.CompressionZstd
+
+		data := bytes.Repeat([]byte("compressible text "), 100000)
+		assert.NoError(t, afs.WriteFile("/notes.txt", data, 0600))
+
+		media, err := service.Upload(service.ctx, "/notes.txt", "")
+		assert.NoError(t, err)
+
+		r, err := backend.List(service.ctx, "'"+media.ID+"' in parents", "")
+		assert.NoError(t, err)
+		var encoded int64
+		for _, f := range r.Files {
+			content, err := backend.Export(service.ctx, f.Id)
+			assert.NoError(t, err)
+			encoded += int64(len(content))
+		}
+
+		folder, err := backend.Get(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.FormatInt(encoded, 10), folder.Properties["encoded_size_numeric"])
+		assert.Equal(t, uds.FormatSize(encoded), media.EncodedSize)
+
+		got, err := service.GetFile(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, media.EncodedSize, got.EncodedSize)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		service, backend, afs := setupBackend(t)
+
+		assert.NoError(t, afs.WriteFile("/empty.txt", nil, 0600))
+
+		root, err := backend.CreateFolder(service.ctx, &drive.File{Name: "parent"})
+		assert.NoError(t, err)
+
+		media, err := service.Upload(service.ctx, "/empty.txt", root.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", media.Mime)
+		assert.Equal(t, []string{root.Id}, media.Parents)
+
+		r, err := backend.List(service.ctx, "'"+media.ID+"' in parents", "")
+		assert.NoError(t, err)
+		assert.Empty(t, r.Files)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		service, _, _ := setupBackend(t)
+
+		_, err := service.Upload(service.ctx, "/not/found", "")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+// Verification struct reports the problems Verify found in a UDS file
+type Verification struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Parts int64  `json:"parts"`
+
+	// Missing parts have no chunk Doc
+	Missing []int64 `json:"missing"`
+	// Duplicated parts have more than one chunk Doc
+	Duplicated []int64 `json:"duplicated"`
+	// Corrupt parts have a chunk Doc failing its checksum or not decoding
+	Corrupt []int64 `json:"corrupt"`
+	// OutOfOrder parts have a chunk Doc whose checksum tells it holds another part
+	OutOfOrder []int64 `json:"out_of_order"`
+	// Unexpected lists the ids of the Docs of the folder that are no chunk of it
+	Unexpected []string `json:"unexpected"`
+
+	// Hashed tells whether the whole file could be reassembled and hashed,
+	// which takes the passphrase of encrypted files
+	Hashed bool `json:"hashed"`
+	// HashMismatch tells the reassembled file does not match its checksum
+	HashMismatch bool `json:"hash_mismatch"`
+}
+
+// OK method reports whether no problem was found
+func (v *Verification) OK() bool {
+	return len(v.Missing) == 0 &&
+		len(v.Duplicated) == 0 &&
+		len(v.Corrupt) == 0 &&
+		len(v.OutOfOrder) == 0 &&
+		len(v.Unexpected) == 0 &&
+		!v.HashMismatch
+}
+
+// Verify downloads every chunk Doc of the UDS file id and checks it against
+// the checksum it was stored with. The chunks are then decoded in part order
+// and the whole file is checked against its SHA-256, or its MD5 for files
+// stored before checksums were recorded. Without the passphrase of an
+// encrypted file, only the chunk checksums are checked.
+func (api *Service) Verify(ctx context.Context, id string) (*Verification, error) {
+	folder, meta, err := api.getMedia(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := api.openTransform(folder, meta)
+	decode := true
+	if err != nil {
+		// the chunk checksums are taken over the stored text, so they can
+		// still be checked without deciphering it
+		if meta.Cipher == "" || len(api.Passphrase) > 0 {
+			return nil, err
+		}
+		codec, err := uds.CodecByName(meta.Codec)
+		if err != nil {
+			return nil, err
+		}
+		t = &transform{codec: codec, compression: meta.Compression}
+		decode = false
+	}
+
+	count := t.numChunks(meta.Size)
+	v := &Verification{ID: folder.Id, Name: folder.Name, Parts: count}
+	media := meta.File(folder.Name, folder.Id, folder.Parents)
+
+	parts := make([][]*drive.File, count)
+	q := NewQuery().Parent(folder.Id).Trashed(false)
+	err = api.listAll(ctx, q.String(), func(f *drive.File) error {
+		part, err := uds.ParsePart(f.Properties)
+		if err != nil || part >= count {
+			v.Unexpected = append(v.Unexpected, f.Id)
+			return nil
+		}
+		parts[part] = append(parts[part], f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for part, docs := range parts {
+		switch {
+		case len(docs) == 0:
+			v.Missing = append(v.Missing, int64(part))
+		case len(docs) > 1:
+			v.Duplicated = append(v.Duplicated, int64(part))
+		}
+	}
+
+	var mu sync.Mutex
+	fetch := func(part int64) ([]byte, error) {
+		var (
+			good       []byte
+			corrupt    bool
+			outOfOrder bool
+		)
+		for _, doc := range parts[part] {
+			chunk := t.chunk(media, part, meta.Size)
+			content, err := api.backend.Export(ctx, doc.Id)
+			if err != nil {
+				return nil, err
+			}
+			chunk.Checksum = doc.Properties[uds.SHA256Property]
+
+			err = chunk.VerifyChecksum(content)
+			if err != nil {
+				// the checksum binds the part, so the text of another part
+				// is told apart from a corrupt one
+				if uds.ChecksumPart(content, chunk.Checksum, count) >= 0 {
+					outOfOrder = true
+					continue
+				}
+			}
+			var b []byte
+			if err == nil && decode {
+				b, err = chunk.Decode(content)
+				if err != nil && chunk.Checksum != "" && t.cipher != nil {
+					// intact text that does not open takes a wrong passphrase
+					return nil, err
+				}
+			}
+			if err != nil {
+				corrupt = true
+			} else if good == nil {
+				good = b
+			}
+		}
+		mu.Lock()
+		if corrupt {
+			v.Corrupt = append(v.Corrupt, part)
+		}
+		if outOfOrder {
+			v.OutOfOrder = append(v.OutOfOrder, part)
+		}
+		mu.Unlock()
+		return good, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var sum hash.Hash
+	want := meta.SHA256
+	if want == "" {
+		sum, want = md5.New(), meta.MD5
+	} else {
+		sum = sha256.New()
+	}
+	whole := decode
+	for future := range prefetch(ctx, 0, count, api.workers(), fetch) {
+		res := <-future
+		if res.err != nil {
+			return nil, res.err
+		}
+		if res.b == nil && whole {
+			// zero length chunks do not exist, so nil means no good copy
+			whole = false
+		}
+		if whole {
+			_, _ = sum.Write(res.b)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, parts := range [][]int64{v.Corrupt, v.OutOfOrder} {
+		sort.Slice(parts, func(a, b int) bool {
+			return parts[a] < parts[b]
+		})
+	}
+	if whole {
+		v.Hashed = true
+		v.HashMismatch = hex.EncodeToString(sum.Sum(nil)) != want
+	}
+	return v, nil
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/zrma/uds-go/pkg/api/drivetest"
+	"github.com/zrma/uds-go/pkg/uds"
+)
+
+func TestVerify(t *testing.T) {
+	setup := func(t *testing.T, passphrase string, parts int64) (*Service, *drivetest.Backend, *uds.File, []*drive.File) {
+		service, backend, afs := setupBackend(t)
+		service.Passphrase = []byte(passphrase)
+
+		data := randomBytes(parts*uds.ChunkReadLengthBytes - 3)
+		assert.NoError(t, afs.WriteFile("/file.bin", data, 0600))
+		media, err := service.Upload(service.ctx, "/file.bin", "")
+		assert.NoError(t, err)
+
+		docs, err := service.listChunks(service.ctx, media.ID, parts)
+		assert.NoError(t, err)
+		return service, backend, media, docs
+	}
+
+	// replace swaps doc for a copy with another title and content
+	replace := func(t *testing.T, backend *drivetest.Backend, doc *drive.File, name, content string) {
+		ctx := context.Background()
+		if content == "" {
+			var err error
+			content, err = backend.Export(ctx, doc.Id)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, backend.Delete(ctx, doc.Id))
+		_, err := backend.CreateDoc(ctx, &drive.File{
+			Name:       name,
+			MimeType:   docMimeType,
+			Parents:    doc.Parents,
+			Properties: doc.Properties,
+		}, content)
+		assert.NoError(t, err)
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		service, _, media, _ := setup(t, "", 2)
+
+		v, err := service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.True(t, v.OK(), v)
+		assert.True(t, v.Hashed)
+		assert.Equal(t, int64(2), v.Parts)
+		assert.Equal(t, "file.bin", v.Name)
+	})
+
+	t.Run("broken parts", func(t *testing.T) {
+		service, backend, media, docs := setup(t, "", 4)
+		ctx := service.ctx
+
+		assert.NoError(t, backend.Delete(ctx, docs[0].Id))
+		content, err := backend.Export(ctx, docs[1].Id)
+		assert.NoError(t, err)
+		_, err = backend.CreateDoc(ctx, &drive.File{
+			Name:       docs[1].Name,
+			MimeType:   docMimeType,
+			Parents:    docs[1].Parents,
+			Properties: docs[1].Properties,
+		}, content)
+		assert.NoError(t, err)
+		replace(t, backend, docs[2], docs[2].Name, uds.Base64.Encode(randomBytes(uds.ChunkReadLengthBytes)))
+		stray, err := backend.CreateDoc(ctx, &drive.File{
+			Name:     "notes",
+			MimeType: docMimeType,
+			Parents:  []string{media.ID},
+		}, "hello")
+		assert.NoError(t, err)
+
+		v, err := service.Verify(ctx, media.ID)
+		assert.NoError(t, err)
+		assert.False(t, v.OK())
+		assert.Equal(t, []int64{0}, v.Missing)
+		assert.Equal(t, []int64{1}, v.Duplicated)
+		assert.Equal(t, []int64{2}, v.Corrupt)
+		assert.Equal(t, []string{stray.Id}, v.Unexpected)
+		assert.False(t, v.Hashed, "the file cannot be reassembled")
+	})
+
+	t.Run("renamed chunks", func(t *testing.T) {
+		service, backend, media, docs := setup(t, "", 2)
+
+		// titles are no part of the format, the part property is
+		replace(t, backend, docs[0], "file.bin1", "")
+		replace(t, backend, docs[1], "renamed", "")
+
+		v, err := service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.True(t, v.OK(), v)
+		assert.True(t, v.Hashed)
+	})
+
+	t.Run("swapped parts", func(t *testing.T) {
+		service, backend, media, docs := setup(t, "", 3)
+
+		// each Doc keeps its text and checksum but is given the part of the other
+		for i, part := range []string{"1", "0"} {
+			docs[i].Properties = map[string]string{
+				uds.PartProperty:   part,
+				uds.SHA256Property: docs[i].Properties[uds.SHA256Property],
+			}
+			replace(t, backend, docs[i], docs[i].Name, "")
+		}
+
+		v, err := service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{0, 1}, v.OutOfOrder)
+		assert.Empty(t, v.Corrupt)
+		assert.False(t, v.Hashed)
+		assert.False(t, v.OK())
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		service, backend, media, docs := setup(t, "", 2)
+
+		// a chunk without checksum, as stored before they were recorded
+		docs[0].Properties = map[string]string{uds.PartProperty: "0"}
+		replace(t, backend, docs[0], docs[0].Name, uds.Base64.Encode(randomBytes(uds.ChunkReadLengthBytes)))
+
+		v, err := service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, v.Corrupt)
+		assert.True(t, v.Hashed)
+		assert.True(t, v.HashMismatch)
+		assert.False(t, v.OK())
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		service, backend, media, docs := setup(t, "secret", 2)
+
+		v, err := service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.True(t, v.OK(), v)
+		assert.True(t, v.Hashed)
+
+		service.Passphrase = nil
+		v, err = service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.True(t, v.OK(), v)
+		assert.False(t, v.Hashed, "chunks cannot be decoded without passphrase")
+
+		service.Passphrase = []byte("wrong")
+		_, err = service.Verify(service.ctx, media.ID)
+		assert.Error(t, err)
+
+		service.Passphrase = nil
+		replace(t, backend, docs[1], docs[1].Name, "tampered")
+		v, err = service.Verify(service.ctx, media.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1}, v.Corrupt)
+	})
+}
@@ -0,0 +1,101 @@
+package uds
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// every character carries 14 bits as an offset from the first CJK
+	// Unified Ideograph, so 7 bytes make 4 characters
+	base16384Start = 0x4e00
+	base16384Bits  = 14
+	base16384Mask  = 1<<base16384Bits - 1
+	base16384Group = 7
+
+	// a last group shorter than 7 bytes is followed by U+3D00 plus its length
+	base16384Tail = 0x3d00
+)
+
+// base16384Codec packs 14 bits in each character, against 6 for base64. A Doc
+// holds about the same number of characters either way, so chunks can carry
+// more than twice the bytes.
+type base16384Codec struct{}
+
+func (base16384Codec) Name() string {
+	return "base16384"
+}
+
+func (base16384Codec) ChunkSize() int64 {
+	// one million characters, as many as a base64 chunk
+	return 1750000
+}
+
+func (base16384Codec) Encode(b []byte) string {
+	var sb strings.Builder
+	sb.Grow((len(b)/base16384Group + 1) * 4 * 3)
+
+	for i := 0; i < len(b); i += base16384Group {
+		var group [base16384Group]byte
+		n := copy(group[:], b[i:])
+
+		var v uint64
+		for _, x := range group {
+			v = v<<8 | uint64(x)
+		}
+		for j := 0; j < base16384Chars(n); j++ {
+			sb.WriteRune(rune(base16384Start + v>>(42-base16384Bits*j)&base16384Mask))
+		}
+		if n < base16384Group {
+			sb.WriteRune(rune(base16384Tail + n))
+		}
+	}
+	return sb.String()
+}
+
+func (base16384Codec) Decode(s string) ([]byte, error) {
+	runes := []rune(s)
+
+	tail := 0
+	if k := len(runes); k > 0 && runes[k-1] > base16384Tail && runes[k-1] < base16384Tail+base16384Group {
+		tail = int(runes[k-1] - base16384Tail)
+		runes = runes[:k-1]
+	}
+	full := len(runes)
+	if tail > 0 {
+		full -= base16384Chars(tail)
+	}
+	if full < 0 || full%4 != 0 {
+		return nil, fmt.Errorf("illegal base16384 data length %d", len(runes))
+	}
+
+	out := make([]byte, 0, full/4*base16384Group+tail)
+	for i := 0; i < len(runes); i += 4 {
+		n := base16384Group
+		if i >= full {
+			n = tail
+		}
+
+		var v uint64
+		for j := 0; j < 4; j++ {
+			v <<= base16384Bits
+			if i+j >= len(runes) {
+				continue
+			}
+			r := runes[i+j]
+			if r < base16384Start || r > base16384Start+base16384Mask {
+				return nil, fmt.Errorf("illegal base16384 data at character %d", i+j)
+			}
+			v |= uint64(r - base16384Start)
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, byte(v>>(48-8*j)))
+		}
+	}
+	return out, nil
+}
+
+// base16384Chars returns the number of characters encoding n bytes of a group
+func base16384Chars(n int) int {
+	return (n*8 + base16384Bits - 1) / base16384Bits
+}
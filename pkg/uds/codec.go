@@ -0,0 +1,96 @@
+package uds
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Codec turns the bytes of a chunk into the text of its Doc and back
+type Codec interface {
+	// Name identifies the codec in the properties of the files it encoded
+	Name() string
+	// ChunkSize is the number of raw bytes stored per chunk Doc. It is part of
+	// the stored format and must not change once files are written with it.
+	ChunkSize() int64
+	Encode(b []byte) string
+	Decode(s string) ([]byte, error)
+}
+
+// Codecs shipped with UDS. Base64 is the default, and the only one files
+// written before codecs were recorded can be read with.
+var (
+	Base64    Codec = base64Codec{}
+	Base16384 Codec = base16384Codec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(Base64)
+	RegisterCodec(Base16384)
+}
+
+// RegisterCodec function makes c available by its name. It panics when the
+// name is taken already.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[c.Name()]; ok {
+		panic("uds: codec " + c.Name() + " registered twice")
+	}
+	codecs[c.Name()] = c
+}
+
+// CodecByName function returns the registered codec called name, Base64 when
+// name is empty
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		return Base64, nil
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %q", name)
+	}
+	return c, nil
+}
+
+// CodecNames function returns the names of the registered codecs, sorted
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type base64Codec struct{}
+
+func (base64Codec) Name() string {
+	return "base64"
+}
+
+func (base64Codec) ChunkSize() int64 {
+	return ChunkReadLengthBytes
+}
+
+func (base64Codec) Encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (base64Codec) Decode(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
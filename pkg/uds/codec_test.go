@@ -0,0 +1,124 @@
+package uds
+
+import (
+	"math/rand"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase64(t *testing.T) {
+	const (
+		str    = `abc123!?$*&()'-=@~`
+		base64 = `YWJjMTIzIT8kKiYoKSctPUB+`
+	)
+
+	t.Run("should encode", func(t *testing.T) {
+		assert.Equal(t, base64, Base64.Encode([]byte(str)))
+	})
+
+	t.Run("should decode", func(t *testing.T) {
+		actual, err := Base64.Decode(base64)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(str), actual)
+	})
+}
+
+func TestBase16384(t *testing.T) {
+	t.Run("known values", func(t *testing.T) {
+		for _, tc := range []struct {
+			given []byte
+			want  string
+		}{
+			{nil, ""},
+			{[]byte{0}, "\u4e00\u3d01"},
+			{[]byte{0xff}, "\u8dc0\u3d01"},
+			{[]byte{0, 0, 0, 0, 0, 0, 0}, "\u4e00\u4e00\u4e00\u4e00"},
+			{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "\u8dff\u8dff\u8dff\u8dff"},
+		} {
+			assert.Equal(t, tc.want, Base16384.Encode(tc.given), tc.given)
+
+			got, err := Base16384.Decode(tc.want)
+			assert.NoError(t, err)
+			assert.Equal(t, len(tc.given), len(got))
+			if len(tc.given) > 0 {
+				assert.Equal(t, tc.given, got)
+			}
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		rnd := rand.New(rand.NewSource(1))
+		for n := 0; n <= 30; n++ {
+			b := make([]byte, n)
+			_, _ = rnd.Read(b)
+
+			s := Base16384.Encode(b)
+			chars := utf8.RuneCountInString(s)
+			assert.True(t, chars*14 >= n*8, n)
+			assert.True(t, chars <= n*8/14+2, n)
+
+			got, err := Base16384.Decode(s)
+			assert.NoError(t, err, n)
+			assert.Equal(t, len(b), len(got), n)
+			assert.Equal(t, b, got, n)
+		}
+	})
+
+	t.Run("chunk fits as many characters as base64", func(t *testing.T) {
+		b := make([]byte, Base16384.ChunkSize())
+		assert.Equal(t, 4*ChunkReadLengthBytes/3, int64(utf8.RuneCountInString(Base16384.Encode(b))))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"abc",
+			"\u4e00",
+			"\u4e00\u4e00\u4e00\u4e00\u4e00",
+			"\u3d01",
+			"\u4e00\u4e00\u3d01",
+			"\u4e00\u9fff\u4e00\u4e00",
+		} {
+			_, err := Base16384.Decode(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestCodecByName(t *testing.T) {
+	c, err := CodecByName("")
+	assert.NoError(t, err)
+	assert.Equal(t, Base64, c)
+
+	c, err = CodecByName("base16384")
+	assert.NoError(t, err)
+	assert.Equal(t, Base16384, c)
+
+	_, err = CodecByName("base65536")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"base16384", "base64"}, CodecNames())
+	assert.Panics(t, func() {
+		RegisterCodec(Base64)
+	})
+}
+
+func TestChunkCodec(t *testing.T) {
+	size := Base16384.ChunkSize() + 10
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+
+	c := &Chunk{Part: 1, MaxSize: size, Media: &File{Name: "a"}, Codec: Base16384}
+	c.Init()
+	assert.Equal(t, Base16384.ChunkSize(), c.RangeStart())
+	assert.Equal(t, int64(10), c.Len())
+
+	content, err := c.Encode(data[c.RangeStart():])
+	assert.NoError(t, err)
+	got, err := c.Decode("\ufeff" + content + "\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, data[c.RangeStart():], got)
+
+	assert.Equal(t, int64(2), NumChunks(size, Base16384.ChunkSize()))
+}
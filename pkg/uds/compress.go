@@ -0,0 +1,135 @@
+package uds
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms applied to chunks before they are sealed and encoded
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressedMimeTypes are formats that are already compressed on their own
+var compressedMimeTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/zstd":             true,
+	"application/pdf":              true,
+	"application/epub+zip":         true,
+}
+
+// uncompressedMediaTypes are image and audio formats storing raw samples
+var uncompressedMediaTypes = map[string]bool{
+	"image/bmp":     true,
+	"image/svg+xml": true,
+	"audio/wav":     true,
+	"audio/x-wav":   true,
+}
+
+// Compressible function reports whether compressing a file of the mime type
+// is worth it. Archives, most images, audio and video are compressed already.
+func Compressible(mime string) bool {
+	mime = strings.ToLower(strings.TrimSpace(strings.SplitN(mime, ";", 2)[0]))
+	if compressedMimeTypes[mime] {
+		return false
+	}
+	if uncompressedMediaTypes[mime] {
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mime, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidCompression function reports whether id names a known compression
+func ValidCompression(id string) bool {
+	switch id {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+// sharedZstdEncoder returns the zstd encoder shared by every chunk, which is
+// safe for concurrent EncodeAll calls
+func sharedZstdEncoder() (*zstd.Encoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+	return zstdEncoder, zstdErr
+}
+
+func compress(id string, b []byte) ([]byte, error) {
+	switch id {
+	case CompressionNone:
+		return b, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		enc, err := sharedZstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(b, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", id)
+}
+
+// decompress inflates b, reading no more than limit bytes so that a forged
+// chunk cannot exhaust memory
+func decompress(id string, b []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch id {
+	case CompressionNone:
+		return b, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", id)
+	}
+	return ioutil.ReadAll(io.LimitReader(r, limit))
+}
@@ -0,0 +1,89 @@
+package uds
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressible(t *testing.T) {
+	for _, tc := range []struct {
+		mime string
+		want bool
+	}{
+		{"text/plain; charset=utf-8", true},
+		{"application/octet-stream", true},
+		{"application/json", true},
+		{"image/svg+xml", true},
+		{"audio/wav", true},
+		{"application/zip", false},
+		{"application/gzip", false},
+		{"Application/X-7z-Compressed", false},
+		{"image/jpeg", false},
+		{"video/mp4", false},
+		{"audio/mpeg", false},
+	} {
+		assert.Equal(t, tc.want, Compressible(tc.mime), tc.mime)
+	}
+}
+
+func TestCompressedChunk(t *testing.T) {
+	data := bytes.Repeat([]byte("all work and no play makes jack a dull boy\n"), 1000)
+
+	for _, id := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run("round trip "+id, func(t *testing.T) {
+			c := &Chunk{Part: 0, MaxSize: int64(len(data)), Media: &File{Name: "a"}, Compression: id}
+			c.Init()
+
+			content, err := c.Encode(data)
+			assert.NoError(t, err)
+			if id != CompressionNone {
+				assert.True(t, len(content) < len(data)/10, "repetitive text should shrink")
+			}
+
+			got, err := c.Decode(content)
+			assert.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+
+	t.Run("with cipher", func(t *testing.T) {
+		salt, err := NewSalt()
+		assert.NoError(t, err)
+		cipher, err := NewCipher(CipherAES256GCM, []byte("secret"), salt)
+		assert.NoError(t, err)
+
+		c := &Chunk{Part: 0, MaxSize: int64(len(data)), Media: &File{Name: "a"}, Compression: CompressionZstd, Cipher: cipher}
+		c.Init()
+
+		content, err := c.Encode(data)
+		assert.NoError(t, err)
+		got, err := c.Decode(content)
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		big := &Chunk{Part: 0, MaxSize: int64(len(data)), Media: &File{Name: "a"}, Compression: CompressionGzip}
+		big.Init()
+		content, err := big.Encode(data)
+		assert.NoError(t, err)
+
+		small := &Chunk{Part: 0, MaxSize: 10, Media: &File{Name: "a"}, Compression: CompressionGzip}
+		small.Init()
+		_, err = small.Decode(content)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		c := &Chunk{Part: 0, MaxSize: 4, Media: &File{Name: "a"}, Compression: "lz4"}
+		c.Init()
+		_, err := c.Encode([]byte("data"))
+		assert.Error(t, err)
+
+		c.Compression = CompressionGzip
+		_, err = c.Decode(Base64.Encode([]byte("not gzip")))
+		assert.Error(t, err)
+	})
+}
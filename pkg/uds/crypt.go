@@ -0,0 +1,93 @@
+package uds
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// CipherAES256GCM is the id of the AES-256-GCM chunk cipher
+const CipherAES256GCM = "aes-256-gcm"
+
+const (
+	saltLength = 16
+	keyLength  = 32
+
+	// scrypt cost parameters recommended for interactive logins
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// Cipher struct seals chunks with a key derived from a passphrase
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher function derives the key of the cipher id from passphrase and salt
+func NewCipher(id string, passphrase, salt []byte) (*Cipher, error) {
+	if id != CipherAES256GCM {
+		return nil, fmt.Errorf("unsupported cipher %q", id)
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// NewSalt function returns a random key derivation salt
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// Seal method encrypts the chunk part behind a random nonce. The part index is
+// authenticated as well, so chunks cannot be swapped without being noticed.
+func (c *Cipher) Seal(part int64, plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, partData(part)), nil
+}
+
+// Open method decrypts and authenticates a chunk sealed by Seal
+func (c *Cipher) Open(part int64, sealed []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("chunk %d: sealed data too short", part)
+	}
+	nonce, data := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, data, partData(part))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %v, wrong passphrase?", part, err)
+	}
+	return plain, nil
+}
+
+func partData(part int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(part))
+	return b
+}
@@ -0,0 +1,82 @@
+package uds
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher(t *testing.T) {
+	salt, err := NewSalt()
+	assert.NoError(t, err)
+
+	c, err := NewCipher(CipherAES256GCM, []byte("secret"), salt)
+	assert.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		plain := []byte("hello, world")
+		sealed, err := c.Seal(3, plain)
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(sealed, plain))
+
+		again, err := c.Seal(3, plain)
+		assert.NoError(t, err)
+		assert.NotEqual(t, sealed, again, "nonce should be random")
+
+		got, err := c.Open(3, sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, got)
+	})
+
+	t.Run("authenticate", func(t *testing.T) {
+		sealed, err := c.Seal(3, []byte("hello, world"))
+		assert.NoError(t, err)
+
+		_, err = c.Open(4, sealed)
+		assert.Error(t, err, "swapped part")
+
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(tampered)-1] ^= 1
+		_, err = c.Open(3, tampered)
+		assert.Error(t, err, "tampered data")
+
+		_, err = c.Open(3, sealed[:4])
+		assert.Error(t, err, "truncated data")
+
+		wrong, err := NewCipher(CipherAES256GCM, []byte("wrong"), salt)
+		assert.NoError(t, err)
+		_, err = wrong.Open(3, sealed)
+		assert.Error(t, err, "wrong passphrase")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewCipher("rot13", []byte("secret"), salt)
+		assert.Error(t, err)
+
+		_, err = NewCipher(CipherAES256GCM, nil, salt)
+		assert.Error(t, err)
+	})
+}
+
+func TestEncryptedChunk(t *testing.T) {
+	salt, err := NewSalt()
+	assert.NoError(t, err)
+	c, err := NewCipher(CipherAES256GCM, []byte("secret"), salt)
+	assert.NoError(t, err)
+
+	chunk := &Chunk{Part: 0, MaxSize: 10, Media: &File{Name: "a"}, Cipher: c}
+	chunk.Init()
+
+	content, err := chunk.Encode([]byte("0123456789"))
+	assert.NoError(t, err)
+
+	plain := &Chunk{Part: 0, MaxSize: 10, Media: &File{Name: "a"}}
+	plain.Init()
+	_, err = plain.Decode(content)
+	assert.Error(t, err, "sealed chunk is longer than the raw bytes")
+
+	got, err := chunk.Decode("\ufeff" + content + "\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), got)
+}
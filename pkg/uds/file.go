@@ -1,17 +1,49 @@
 package uds
 
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ChunkReadLengthBytes is the number of raw bytes stored in a single base64 chunk
+const ChunkReadLengthBytes int64 = 750000
+
 // File struct is file wrapper
 type File struct {
-	Name        string
-	Mime        string
-	Size        string
-	EncodedSize string
-	SizeNumeric string
-	Parents     []string
+	Name        string   `json:"name"`
+	Mime        string   `json:"mime"`
+	Size        string   `json:"size"`
+	EncodedSize string   `json:"encoded_size"`
+	SizeNumeric string   `json:"size_numeric"`
+	Parents     []string `json:"parents"`
+
+	ID      string `json:"id"`
+	MD5     string `json:"md5"`
+	SHA256  string `json:"sha256"`
+	Shared  bool   `json:"shared"`
+	Trashed bool   `json:"trashed"`
+}
 
-	ID     string
-	MD5    string
-	Shared bool
+// NewFile function returns File describing a local file of the given size.
+// EncodedSize is left empty, as it depends on how the chunks are stored.
+func NewFile(name, mime string, size int64, md5 string, parents []string) *File {
+	f := &File{
+		Name:        name,
+		Mime:        mime,
+		Size:        FormatSize(size),
+		SizeNumeric: strconv.FormatInt(size, 10),
+		Parents:     parents,
+		MD5:         md5,
+	}
+	f.Init()
+	return f
 }
 
 // Init method initialize parents of File struct not to be nil
@@ -21,6 +53,15 @@ func (f *File) Init() {
 	}
 }
 
+// NumChunks function returns the number of chunks of chunkSize bytes needed
+// to store size bytes
+func NumChunks(size, chunkSize int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
 // Chunk struct is split file chunk
 type Chunk struct {
 	Path    string
@@ -28,15 +69,158 @@ type Chunk struct {
 	MaxSize int64
 	Media   *File
 	Parent  string
+	// Codec turns the processed bytes into Doc text, Base64 when nil
+	Codec Codec
+	// Compression is applied to the raw bytes first, CompressionNone to skip it
+	Compression string
+	// Cipher seals the raw bytes before they are encoded, nil to store them in clear
+	Cipher *Cipher
+	// Checksum is the SHA-256 of the Doc text, recorded by Encode and checked
+	// by Decode when set
+	Checksum string
 
 	RangeEnd int64
 }
 
 // Init method initialize parents of Chunk struct range end boundary
 func (c *Chunk) Init() {
-	const chunkReadLengthBytes int64 = 750000
-	c.RangeEnd = (c.Part + 1) * chunkReadLengthBytes
+	c.RangeEnd = (c.Part + 1) * c.codec().ChunkSize()
 	if c.RangeEnd > c.MaxSize {
 		c.RangeEnd = c.MaxSize
 	}
 }
+
+func (c *Chunk) codec() Codec {
+	if c.Codec == nil {
+		return Base64
+	}
+	return c.Codec
+}
+
+// RangeStart method returns the offset of the first byte covered by the chunk
+func (c *Chunk) RangeStart() int64 {
+	return c.Part * c.codec().ChunkSize()
+}
+
+// Len method returns the number of raw bytes covered by the chunk
+func (c *Chunk) Len() int64 {
+	return c.RangeEnd - c.RangeStart()
+}
+
+// Name method returns the title of the Doc holding the chunk
+func (c *Chunk) Name() string {
+	return fmt.Sprintf("%s%d", c.Media.Name, c.Part)
+}
+
+// Read method reads the raw bytes covered by the chunk from r
+func (c *Chunk) Read(r io.ReaderAt) ([]byte, error) {
+	buf := make([]byte, c.Len())
+	n, err := r.ReadAt(buf, c.RangeStart())
+	if n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Encode method converts the raw bytes of the chunk to the text of its Doc
+func (c *Chunk) Encode(b []byte) (string, error) {
+	if int64(len(b)) != c.Len() {
+		return "", fmt.Errorf("chunk %d: got %d bytes, want %d", c.Part, len(b), c.Len())
+	}
+	b, err := compress(c.Compression, b)
+	if err != nil {
+		return "", err
+	}
+	if c.Cipher != nil {
+		sealed, err := c.Cipher.Seal(c.Part, b)
+		if err != nil {
+			return "", err
+		}
+		b = sealed
+	}
+	content := c.codec().Encode(b)
+	c.Checksum = Checksum(c.Part, content)
+	return content, nil
+}
+
+// Checksum function returns the hex SHA-256 of the text of a chunk Doc
+// followed by its part, so that a Doc given the part of another one fails it
+func Checksum(part int64, content string) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(normalize(content)))
+	return hex.EncodeToString(appendPart(h, part).Sum(nil))
+}
+
+// ChecksumPart function returns which of the count parts the text of a chunk
+// Doc was stored as according to its checksum sum, or -1 when none
+func ChecksumPart(content, sum string, count int64) int64 {
+	h := sha256.New()
+	_, _ = h.Write([]byte(normalize(content)))
+	// the text is hashed once, and the state cloned for every part
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return -1
+	}
+	for part := int64(0); part < count; part++ {
+		h := sha256.New()
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return -1
+		}
+		if hex.EncodeToString(appendPart(h, part).Sum(nil)) == sum {
+			return part
+		}
+	}
+	return -1
+}
+
+func appendPart(h hash.Hash, part int64) hash.Hash {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(part))
+	_, _ = h.Write(b[:])
+	return h
+}
+
+// normalize strips what Drive adds to the text of a Doc when it is exported
+func normalize(content string) string {
+	// exported Docs come back with a byte order mark and trailing line breaks
+	return strings.TrimSpace(strings.TrimPrefix(content, "\ufeff"))
+}
+
+// VerifyChecksum method checks the text of a chunk Doc against the Checksum
+// of the chunk, if any
+func (c *Chunk) VerifyChecksum(content string) error {
+	if c.Checksum == "" {
+		return nil
+	}
+	if got := Checksum(c.Part, content); got != c.Checksum {
+		return fmt.Errorf("chunk %d: sha256 mismatch: got %s, want %s", c.Part, got, c.Checksum)
+	}
+	return nil
+}
+
+// Decode method converts the text of a chunk Doc back to raw bytes
+func (c *Chunk) Decode(content string) ([]byte, error) {
+	if err := c.VerifyChecksum(content); err != nil {
+		return nil, err
+	}
+	b, err := c.codec().Decode(normalize(content))
+	if err != nil {
+		return nil, err
+	}
+	if c.Cipher != nil {
+		if b, err = c.Cipher.Open(c.Part, b); err != nil {
+			return nil, err
+		}
+	}
+	// one byte past the expected length is enough to tell it is too long
+	if b, err = decompress(c.Compression, b, c.Len()+1); err != nil {
+		return nil, fmt.Errorf("chunk %d: %v", c.Part, err)
+	}
+	if int64(len(b)) != c.Len() {
+		return nil, fmt.Errorf("chunk %d: got %d bytes, want %d", c.Part, len(b), c.Len())
+	}
+	return b, nil
+}
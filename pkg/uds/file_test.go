@@ -1 +1,143 @@
 package uds
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFile(t *testing.T) {
+	t.Run("fill sizes", func(t *testing.T) {
+		f := NewFile("a.txt", "text/plain", 2048, "md5-1234", nil)
+		assert.Equal(t, "a.txt", f.Name)
+		assert.Equal(t, "text/plain", f.Mime)
+		assert.Equal(t, "2.0 KB", f.Size)
+		assert.Empty(t, f.EncodedSize)
+		assert.Equal(t, "2048", f.SizeNumeric)
+		assert.Equal(t, "md5-1234", f.MD5)
+		assert.Equal(t, []string{"root"}, f.Parents)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		f := NewFile("empty", "", 0, "", []string{"parent-1"})
+		assert.Equal(t, "0 bytes", f.Size)
+		assert.Equal(t, "0", f.SizeNumeric)
+		assert.Equal(t, []string{"parent-1"}, f.Parents)
+	})
+}
+
+func TestNumChunks(t *testing.T) {
+	for _, tc := range []struct {
+		given int64
+		want  int64
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, 1},
+		{ChunkReadLengthBytes, 1},
+		{ChunkReadLengthBytes + 1, 2},
+		{3 * ChunkReadLengthBytes, 3},
+	} {
+		assert.Equal(t, tc.want, NumChunks(tc.given, ChunkReadLengthBytes), tc.given)
+	}
+}
+
+func TestChunk(t *testing.T) {
+	const size = ChunkReadLengthBytes + 10
+
+	data := bytes.Repeat([]byte("0123456789"), int(size/10))
+	media := &File{Name: "data.bin"}
+
+	t.Run("first chunk", func(t *testing.T) {
+		c := &Chunk{Part: 0, MaxSize: size, Media: media}
+		c.Init()
+
+		assert.Equal(t, int64(0), c.RangeStart())
+		assert.Equal(t, ChunkReadLengthBytes, c.RangeEnd)
+		assert.Equal(t, ChunkReadLengthBytes, c.Len())
+		assert.Equal(t, "data.bin0", c.Name())
+
+		b, err := c.Read(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, data[:ChunkReadLengthBytes], b)
+
+		got, err := c.Encode(b)
+		assert.NoError(t, err)
+		assert.Equal(t, Base64.Encode(data[:ChunkReadLengthBytes]), got)
+	})
+
+	t.Run("last chunk", func(t *testing.T) {
+		c := &Chunk{Part: 1, MaxSize: size, Media: media}
+		c.Init()
+
+		assert.Equal(t, ChunkReadLengthBytes, c.RangeStart())
+		assert.Equal(t, size, c.RangeEnd)
+		assert.Equal(t, int64(10), c.Len())
+		assert.Equal(t, "data.bin1", c.Name())
+
+		b, err := c.Read(bytes.NewReader(data))
+		assert.NoError(t, err)
+
+		got, err := c.Encode(b)
+		assert.NoError(t, err)
+		assert.Equal(t, Base64.Encode([]byte("0123456789")), got)
+
+		_, err = c.Encode(b[1:])
+		assert.Error(t, err)
+	})
+
+	t.Run("decode exported doc", func(t *testing.T) {
+		c := &Chunk{Part: 1, MaxSize: size, Media: media}
+		c.Init()
+
+		got, err := c.Decode("\ufeff" + Base64.Encode([]byte("0123456789")) + "\r\n")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("0123456789"), got)
+	})
+
+	t.Run("decode wrong length", func(t *testing.T) {
+		c := &Chunk{Part: 1, MaxSize: size, Media: media}
+		c.Init()
+
+		_, err := c.Decode(Base64.Encode([]byte("012345678")))
+		assert.Error(t, err)
+
+		_, err = c.Decode("not base64!")
+		assert.Error(t, err)
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		c := &Chunk{Part: 1, MaxSize: size, Media: media}
+		c.Init()
+
+		content, err := c.Encode([]byte("0123456789"))
+		assert.NoError(t, err)
+		assert.Equal(t, Checksum(1, content), c.Checksum)
+		assert.NotEqual(t, Checksum(0, content), c.Checksum, "the part should be bound")
+		assert.Equal(t, int64(1), ChecksumPart("\ufeff"+content, c.Checksum, 3))
+		assert.Equal(t, int64(-1), ChecksumPart(content, c.Checksum, 1))
+		assert.Equal(t, c.Checksum, c.Properties()["sha256"])
+
+		got, err := c.Decode("\ufeff" + content + "\r\n")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("0123456789"), got)
+
+		tampered := Base64.Encode([]byte("9876543210"))
+		assert.Error(t, c.VerifyChecksum(tampered))
+		_, err = c.Decode(tampered)
+		assert.Error(t, err)
+
+		c.Checksum = ""
+		assert.NoError(t, c.VerifyChecksum(tampered))
+	})
+
+	t.Run("source shorter than chunk", func(t *testing.T) {
+		c := &Chunk{Part: 1, MaxSize: size, Media: media}
+		c.Init()
+
+		_, err := c.Read(bytes.NewReader(data[:size-1]))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
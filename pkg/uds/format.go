@@ -29,6 +29,15 @@ func calcSize(numOfBytes int64) (size float64, idx sizeIndex) {
 	return
 }
 
+// FormatSize function returns numOfBytes in a human-readable form
+func FormatSize(numOfBytes int64) string {
+	s, err := format(numOfBytes)
+	if err != nil {
+		return "0 bytes"
+	}
+	return s
+}
+
 func format(numOfBytes int64) (string, error) {
 	size, idx := calcSize(numOfBytes)
 	if idx == invalid {
@@ -38,4 +38,7 @@ func TestUtil(t *testing.T) {
 			assert.Equal(t, tc.want, size)
 		})
 	}
+
+	assert.Equal(t, "1.0 KB", FormatSize(kb))
+	assert.Equal(t, "0 bytes", FormatSize(0))
 }
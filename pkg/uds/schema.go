@@ -0,0 +1,272 @@
+package uds
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the version of the properties written on media folders.
+// Version 2 added client-side encryption, compression and the codec of chunk
+// Docs. Folders only take it when they use one of them, so that readers of
+// version 1 still read the others: optional properties like the SHA-256
+// checksums need no new version, as readers ignore what they do not know.
+const SchemaVersion = 2
+
+// Drive properties tagging UDS folders and chunk Docs
+const (
+	RootProperty               = "udsRoot"
+	QuarantineProperty         = "udsQuarantine"
+	MediaProperty              = "uds"
+	VersionProperty            = "uds_version"
+	SizeProperty               = "size"
+	SizeNumericProperty        = "size_numeric"
+	EncodedSizeProperty        = "encoded_size"
+	EncodedSizeNumericProperty = "encoded_size_numeric"
+	MD5Property                = "md5"
+	MimeProperty               = "mime_type"
+	PartProperty               = "part"
+	ChunkProperty              = "uds_chunk"
+	CipherProperty             = "cipher"
+	SaltProperty               = "kdf_salt"
+	CompressionProperty        = "compression"
+	CodecProperty              = "codec"
+	SHA256Property             = "sha256"
+)
+
+// ErrPassphraseRequired is returned when reading an encrypted file without passphrase
+var ErrPassphraseRequired = errors.New("file is encrypted, passphrase required")
+
+// Metadata struct is the schema of the properties of a media folder
+type Metadata struct {
+	Version int
+	Mime    string
+	Size    int64
+	MD5     string
+	// SHA256 is the checksum of the whole file, empty for files stored before
+	// it was recorded
+	SHA256 string
+
+	// Codec is the name of the codec of the chunk Docs, empty for Base64
+	Codec string
+	// Compression is the algorithm the chunks are compressed with, if any
+	Compression string
+	// Cipher is the id of the cipher sealing the chunks, empty when stored in clear
+	Cipher string
+	// Salt is the salt the cipher key is derived with from the passphrase
+	Salt []byte
+
+	// EncodedSize is the number of bytes of text stored in the chunk Docs,
+	// zero until they are all uploaded and for files stored before it was recorded
+	EncodedSize int64
+}
+
+// Properties method marshals m to Drive properties at the lowest version
+// that can read them
+func (m *Metadata) Properties() map[string]string {
+	props := map[string]string{
+		MediaProperty:       "true",
+		VersionProperty:     strconv.Itoa(m.requiredVersion()),
+		SizeProperty:        FormatSize(m.Size),
+		SizeNumericProperty: strconv.FormatInt(m.Size, 10),
+		MD5Property:         m.MD5,
+		MimeProperty:        m.Mime,
+	}
+	if size, ok := m.encodedSize(); ok {
+		props[EncodedSizeProperty] = FormatSize(size)
+	}
+	if m.EncodedSize > 0 {
+		props[EncodedSizeNumericProperty] = strconv.FormatInt(m.EncodedSize, 10)
+	}
+	if m.SHA256 != "" {
+		props[SHA256Property] = m.SHA256
+	}
+	if m.Codec != "" {
+		props[CodecProperty] = m.Codec
+	}
+	if m.Compression != CompressionNone {
+		props[CompressionProperty] = m.Compression
+	}
+	if m.Cipher != "" {
+		props[CipherProperty] = m.Cipher
+		props[SaltProperty] = base64.StdEncoding.EncodeToString(m.Salt)
+	}
+	return props
+}
+
+// Encrypt method makes m describe chunks sealed with a key derived from
+// passphrase and a new random salt, and returns the cipher sealing them
+func (m *Metadata) Encrypt(passphrase []byte) (*Cipher, error) {
+	salt, err := NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCipher(CipherAES256GCM, passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	m.Cipher = CipherAES256GCM
+	m.Salt = salt
+	return c, nil
+}
+
+// OpenCipher method returns the cipher the chunks described by m were sealed
+// with, or nil when they are stored in clear
+func (m *Metadata) OpenCipher(passphrase []byte) (*Cipher, error) {
+	if m.Cipher == "" {
+		return nil, nil
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	return NewCipher(m.Cipher, passphrase, m.Salt)
+}
+
+// requiredVersion returns the lowest schema version readers of the chunks
+// described by m must support
+func (m *Metadata) requiredVersion() int {
+	if m.Cipher != "" || m.Compression != CompressionNone || m.Codec != "" {
+		return 2
+	}
+	return 1
+}
+
+// encodedSize returns the number of bytes of text in the chunk Docs
+// described by m, and whether it is known. Base64 chunks hold a multiple of 3
+// bytes, so the size of clear Base64 ones follows from the size of the file;
+// the others can only be measured while they are encoded.
+func (m *Metadata) encodedSize() (int64, bool) {
+	switch {
+	case m.EncodedSize > 0 || m.Size == 0:
+		return m.EncodedSize, true
+	case m.requiredVersion() == 1:
+		return 4 * ((m.Size + 2) / 3), true
+	}
+	return 0, false
+}
+
+// Outdated method reports whether m was read from an older schema than the
+// one it is written with
+func (m *Metadata) Outdated() bool {
+	return m.Version < m.requiredVersion()
+}
+
+// File method returns the File described by m
+func (m *Metadata) File(name, id string, parents []string) *File {
+	f := NewFile(name, m.Mime, m.Size, m.MD5, parents)
+	f.ID = id
+	f.SHA256 = m.SHA256
+	if size, ok := m.encodedSize(); ok {
+		f.EncodedSize = FormatSize(size)
+	}
+	return f
+}
+
+// ParseMetadata function unmarshals the properties of a media folder. Folders
+// written before versioning have no version property and are read as version
+// 0, including the ones wrongly tagged as UDS root by early releases.
+func ParseMetadata(props map[string]string) (*Metadata, error) {
+	version := 0
+	if v, ok := props[VersionProperty]; ok {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid schema version %q", v)
+		}
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("unsupported schema version %d", version)
+	}
+
+	_, hasSize := props[SizeNumericProperty]
+	isMedia := props[MediaProperty] == "true"
+	if version == 0 && !isMedia {
+		isMedia = props[RootProperty] == "true" && hasSize
+	}
+	if !isMedia {
+		return nil, errors.New("not a UDS media folder")
+	}
+
+	size, err := strconv.ParseInt(props[SizeNumericProperty], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid size %q", props[SizeNumericProperty])
+	}
+
+	var encodedSize int64
+	if v, ok := props[EncodedSizeNumericProperty]; ok {
+		if encodedSize, err = strconv.ParseInt(v, 10, 64); err != nil || encodedSize < 0 {
+			return nil, fmt.Errorf("invalid encoded size %q", v)
+		}
+	}
+
+	meta := &Metadata{
+		Version: version,
+		Mime:    props[MimeProperty],
+		Size:    size,
+		MD5:     props[MD5Property],
+		SHA256:  props[SHA256Property],
+
+		EncodedSize: encodedSize,
+	}
+	if name := props[CodecProperty]; name != "" {
+		if _, err := CodecByName(name); err != nil {
+			return nil, err
+		}
+		meta.Codec = name
+	}
+	if id := props[CompressionProperty]; id != CompressionNone {
+		if !ValidCompression(id) {
+			return nil, fmt.Errorf("unsupported compression %q", id)
+		}
+		meta.Compression = id
+	}
+	if id, ok := props[CipherProperty]; ok {
+		if id != CipherAES256GCM {
+			return nil, fmt.Errorf("unsupported cipher %q", id)
+		}
+		salt, err := base64.StdEncoding.DecodeString(props[SaltProperty])
+		if err != nil || len(salt) == 0 {
+			return nil, fmt.Errorf("invalid key derivation salt %q", props[SaltProperty])
+		}
+		meta.Cipher = id
+		meta.Salt = salt
+	}
+	return meta, nil
+}
+
+// Metadata method returns the schema of the media folder holding f
+func (f *File) Metadata() (*Metadata, error) {
+	size, err := strconv.ParseInt(f.SizeNumeric, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size %q", f.SizeNumeric)
+	}
+	m := &Metadata{
+		Mime:   f.Mime,
+		Size:   size,
+		MD5:    f.MD5,
+		SHA256: f.SHA256,
+	}
+	m.Version = m.requiredVersion()
+	return m, nil
+}
+
+// Properties method returns the Drive properties of the Doc holding c
+func (c *Chunk) Properties() map[string]string {
+	props := map[string]string{
+		ChunkProperty: "true",
+		PartProperty:  strconv.FormatInt(c.Part, 10),
+	}
+	if c.Checksum != "" {
+		props[SHA256Property] = c.Checksum
+	}
+	return props
+}
+
+// ParsePart function returns the part index stored in chunk Doc properties
+func ParsePart(props map[string]string) (int64, error) {
+	part, err := strconv.ParseInt(props[PartProperty], 10, 64)
+	if err != nil || part < 0 {
+		return 0, fmt.Errorf("invalid part %q", props[PartProperty])
+	}
+	return part, nil
+}
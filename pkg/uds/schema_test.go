@@ -0,0 +1,158 @@
+package uds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		// files in clear, compressed with nothing, are read by version 1
+		given := &Metadata{Version: 1, Mime: "text/plain", Size: 2048, MD5: "md5-1234", SHA256: "sha256-1234"}
+
+		props := given.Properties()
+		assert.Equal(t, map[string]string{
+			"uds":          "true",
+			"uds_version":  "1",
+			"size":         "2.0 KB",
+			"size_numeric": "2048",
+			"encoded_size": "2.7 KB",
+			"md5":          "md5-1234",
+			"sha256":       "sha256-1234",
+			"mime_type":    "text/plain",
+		}, props)
+
+		got, err := ParseMetadata(props)
+		assert.NoError(t, err)
+		assert.Equal(t, given, got)
+		assert.False(t, got.Outdated())
+
+		f := got.File("a.txt", "id-1", []string{"parent-1"})
+		assert.Equal(t, "a.txt", f.Name)
+		assert.Equal(t, "id-1", f.ID)
+		assert.Equal(t, "2.0 KB", f.Size)
+		assert.Equal(t, "2.7 KB", f.EncodedSize)
+		assert.Equal(t, []string{"parent-1"}, f.Parents)
+
+		back, err := f.Metadata()
+		assert.NoError(t, err)
+		assert.Equal(t, given, back)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		given := &Metadata{Version: SchemaVersion, Size: 10, MD5: "md5-1"}
+		c, err := given.Encrypt([]byte("secret"))
+		assert.NoError(t, err)
+		assert.NotNil(t, c)
+		assert.Equal(t, CipherAES256GCM, given.Cipher)
+		assert.Len(t, given.Salt, saltLength)
+
+		props := given.Properties()
+		assert.Equal(t, CipherAES256GCM, props["cipher"])
+		assert.NotEmpty(t, props["kdf_salt"])
+
+		got, err := ParseMetadata(props)
+		assert.NoError(t, err)
+		assert.Equal(t, given, got)
+
+		_, err = got.OpenCipher(nil)
+		assert.Equal(t, ErrPassphraseRequired, err)
+
+		opened, err := got.OpenCipher([]byte("secret"))
+		assert.NoError(t, err)
+		sealed, err := c.Seal(0, []byte("data"))
+		assert.NoError(t, err)
+		plain, err := opened.Open(0, sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data"), plain)
+
+		clear, err := (&Metadata{}).OpenCipher([]byte("secret"))
+		assert.NoError(t, err)
+		assert.Nil(t, clear)
+	})
+
+	t.Run("codec and compression", func(t *testing.T) {
+		given := &Metadata{Version: SchemaVersion, Size: 10, MD5: "md5-1", Codec: "base16384", Compression: CompressionZstd}
+
+		props := given.Properties()
+		assert.Equal(t, "base16384", props["codec"])
+		assert.Equal(t, "zstd", props["compression"])
+		assert.Equal(t, "2", props["uds_version"])
+
+		got, err := ParseMetadata(props)
+		assert.NoError(t, err)
+		assert.Equal(t, given, got)
+	})
+
+	t.Run("encoded size", func(t *testing.T) {
+		given := &Metadata{Version: SchemaVersion, Size: 2048, MD5: "md5-1", Codec: "base16384"}
+		assert.NotContains(t, given.Properties(), "encoded_size")
+		assert.Empty(t, given.File("a.txt", "id-1", nil).EncodedSize)
+
+		given.EncodedSize = 3516
+		props := given.Properties()
+		assert.Equal(t, "3.4 KB", props["encoded_size"])
+		assert.Equal(t, "3516", props["encoded_size_numeric"])
+
+		got, err := ParseMetadata(props)
+		assert.NoError(t, err)
+		assert.Equal(t, given, got)
+		assert.Equal(t, "3.4 KB", got.File("a.txt", "id-1", nil).EncodedSize)
+	})
+
+	t.Run("legacy folders", func(t *testing.T) {
+		for _, props := range []map[string]string{
+			{"uds": "true", "size_numeric": "10", "md5": "md5-1"},
+			{"udsRoot": "true", "size_numeric": "10", "md5": "md5-1"},
+		} {
+			got, err := ParseMetadata(props)
+			assert.NoError(t, err)
+			assert.Equal(t, &Metadata{Version: 0, Size: 10, MD5: "md5-1"}, got)
+			assert.True(t, got.Outdated())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, props := range []map[string]string{
+			nil,
+			{"udsRoot": "true"},
+			{"uds": "true"},
+			{"uds": "true", "size_numeric": "-1"},
+			{"uds": "true", "size_numeric": "10", "uds_version": "x"},
+			{"uds": "true", "size_numeric": "10", "uds_version": "0"},
+			{"uds": "true", "size_numeric": "10", "uds_version": "99"},
+			{"udsRoot": "true", "size_numeric": "10", "uds_version": "1"},
+			{"uds": "true", "size_numeric": "10", "compression": "lz4"},
+			{"uds": "true", "size_numeric": "10", "codec": "base65536"},
+			{"uds": "true", "size_numeric": "10", "encoded_size_numeric": "-1"},
+			{"uds": "true", "size_numeric": "10", "cipher": "rot13", "kdf_salt": "c2FsdA=="},
+			{"uds": "true", "size_numeric": "10", "cipher": "aes-256-gcm"},
+			{"uds": "true", "size_numeric": "10", "cipher": "aes-256-gcm", "kdf_salt": "!"},
+		} {
+			_, err := ParseMetadata(props)
+			assert.Error(t, err, props)
+		}
+
+		_, err := (&File{SizeNumeric: "x"}).Metadata()
+		assert.Error(t, err)
+	})
+}
+
+func TestChunkProperties(t *testing.T) {
+	c := &Chunk{Part: 12}
+	props := c.Properties()
+	assert.Equal(t, map[string]string{"uds_chunk": "true", "part": "12"}, props)
+
+	part, err := ParsePart(props)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), part)
+
+	for _, props := range []map[string]string{nil, {"part": "x"}, {"part": "-1"}} {
+		_, err := ParsePart(props)
+		assert.Error(t, err)
+	}
+
+	c.Checksum = "sha256-1"
+	assert.Equal(t, map[string]string{"uds_chunk": "true", "part": "12", "sha256": "sha256-1"}, c.Properties())
+}